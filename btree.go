@@ -0,0 +1,376 @@
+package main
+
+import "bytes"
+
+// Minimum occupancy (in bytes) below which a node is a merge candidate.
+// Mirrors the page-size/4 threshold used by bbolt's node.rebalance.
+const BTREE_MIN_FILL_SIZE = BTREE_PAGE_SIZE / 4
+
+func assert(cond bool) {
+	if !cond {
+		panic("assertion failure")
+	}
+}
+
+// Insert inserts or updates a key. Copy-on-write: every touched page on the
+// path from root to leaf is replaced with a new page, and the old pages are
+// freed via tree.del.
+func (tree *BTREE) Insert(key []byte, val []byte) {
+	if len(key) == 0 {
+		panic("empty key")
+	}
+	if len(key) > BTREE_MAX_KEY_SIZE || len(val) > BTREE_MAX_VAL_SIZE {
+		panic("key or value too large")
+	}
+
+	if tree.root == 0 {
+		// first insert: create a leaf with a sentinel empty-key entry so
+		// nodeLookupLE always has something to fall back to at idx 0.
+		// Leaves are prefix-compressed by default (see node_compressed.go):
+		// every mutation helper (leafInsert/leafUpdate/leafDelete/
+		// nodeSplit2) propagates BNODE_COMPRESSED from the node it derives
+		// from, so it's carried from here through the tree's whole life.
+		root := BNode(make([]byte, BTREE_PAGE_SIZE))
+		root.setHeader(BNODE_LEAF|BNODE_COMPRESSED, 2)
+		nodeAppendKV(root, 0, 0, nil, nil)
+		nodeAppendKV(root, 1, 0, key, val)
+		tree.root = tree.new(root)
+		return
+	}
+
+	node := treeInsert(tree, BNode(tree.get(tree.root)), key, val)
+	nsplit, split := nodeSplit3(node)
+	tree.del(tree.root)
+	if nsplit > 1 {
+		// the root split: grow the tree by one level
+		root := BNode(make([]byte, BTREE_PAGE_SIZE))
+		root.setHeader(BNODE_NODE, nsplit)
+		for i, knode := range split[:nsplit] {
+			ptr, key := tree.new(knode), knode.getKey(0)
+			nodeAppendKV(root, uint16(i), ptr, key, nil)
+		}
+		tree.root = tree.new(root)
+	} else {
+		tree.root = tree.new(split[0])
+	}
+}
+
+// Get looks up a key and reports whether it was found.
+func (tree *BTREE) Get(key []byte) ([]byte, bool) {
+	if tree.root == 0 {
+		return nil, false
+	}
+	return treeGet(tree, BNode(tree.get(tree.root)), key)
+}
+
+func treeGet(tree *BTREE, node BNode, key []byte) ([]byte, bool) {
+	idx := nodeLookupLE(node, key)
+	switch node.kind() {
+	case BNODE_LEAF:
+		if bytes.Equal(key, node.getKey(idx)) {
+			return node.getVal(idx), true
+		}
+		return nil, false
+	case BNODE_NODE:
+		return treeGet(tree, BNode(tree.get(node.getPtr(idx))), key)
+	default:
+		panic("bad node")
+	}
+}
+
+// Delete removes a key, reporting whether it was present. It rebalances
+// (merges or redistributes into a sibling) any node that falls below
+// BTREE_MIN_FILL_SIZE after the removal, and shrinks the tree height when
+// the root is left with a single child.
+func (tree *BTREE) Delete(key []byte) bool {
+	if tree.root == 0 {
+		return false
+	}
+
+	node := treeDelete(tree, BNode(tree.get(tree.root)), key)
+	if node == nil {
+		return false
+	}
+
+	tree.del(tree.root)
+	if node.kind() == BNODE_NODE && node.nkeys() == 1 {
+		// root now has a single child: drop a level
+		tree.root = node.getPtr(0)
+	} else {
+		tree.root = tree.new(node)
+	}
+	return true
+}
+
+// treeInsert recursively inserts (key, val) under node, returning the
+// (possibly oversized) replacement node. The caller is responsible for
+// splitting it back down with nodeSplit3.
+func treeInsert(tree *BTREE, node BNode, key []byte, val []byte) BNode {
+	new := BNode(make([]byte, 2*BTREE_PAGE_SIZE))
+
+	idx := nodeLookupLE(node, key)
+	switch node.kind() {
+	case BNODE_LEAF:
+		if bytes.Equal(key, node.getKey(idx)) {
+			leafUpdate(new, node, idx, key, val)
+		} else {
+			leafInsert(new, node, idx+1, key, val)
+		}
+	case BNODE_NODE:
+		nodeInsert(tree, new, node, idx, key, val)
+	default:
+		panic("bad node")
+	}
+	return new
+}
+
+// update an existing leaf key in place
+func leafUpdate(new BNode, old BNode, idx uint16, key []byte, val []byte) {
+	new.setHeader(old.btype(), old.nkeys()) // preserves BNODE_COMPRESSED
+	nodeAppendRange(new, old, 0, 0, idx)
+	nodeAppendKV(new, idx, 0, key, val)
+	nodeAppendRange(new, old, idx+1, idx+1, old.nkeys()-idx-1)
+}
+
+// recurse into a kid, insert, then split it back into the parent
+func nodeInsert(tree *BTREE, new BNode, node BNode, idx uint16, key []byte, val []byte) {
+	kptr := node.getPtr(idx)
+	knode := treeInsert(tree, BNode(tree.get(kptr)), key, val)
+	tree.del(kptr)
+
+	nsplit, split := nodeSplit3(knode)
+	nodeReplaceKidN(tree, new, node, idx, split[:nsplit]...)
+}
+
+// split an oversized node into at most 3 nodes, each within BTREE_PAGE_SIZE
+func nodeSplit3(old BNode) (uint16, [3]BNode) {
+	if old.nbytes() <= BTREE_PAGE_SIZE {
+		old = old[:BTREE_PAGE_SIZE]
+		return 1, [3]BNode{old}
+	}
+
+	left := BNode(make([]byte, 2*BTREE_PAGE_SIZE))
+	right := BNode(make([]byte, BTREE_PAGE_SIZE))
+	nodeSplit2(left, right, old)
+	if left.nbytes() <= BTREE_PAGE_SIZE {
+		left = left[:BTREE_PAGE_SIZE]
+		return 2, [3]BNode{left, right}
+	}
+
+	leftleft := BNode(make([]byte, BTREE_PAGE_SIZE))
+	middle := BNode(make([]byte, BTREE_PAGE_SIZE))
+	nodeSplit2(leftleft, middle, left)
+	assert(leftleft.nbytes() <= BTREE_PAGE_SIZE)
+	return 3, [3]BNode{leftleft, middle, right}
+}
+
+// split an oversized node into exactly 2, growing/shrinking the split point
+// until `left` fits within one page (it may still be oversized, in which
+// case the caller splits it again)
+func nodeSplit2(left BNode, right BNode, old BNode) {
+	nold := old.nkeys()
+	nleft := nold / 2
+
+	left_bytes := func() uint16 {
+		return HEADER + 8*nleft + 2*nleft + old.getOffset(nleft)
+	}
+	for left_bytes() > BTREE_PAGE_SIZE {
+		nleft--
+	}
+	assert(nleft >= 1)
+
+	right_bytes := func() uint16 {
+		return old.nbytes() - left_bytes() + HEADER
+	}
+	for right_bytes() > BTREE_PAGE_SIZE {
+		nleft++
+	}
+	assert(nleft < nold)
+	nright := nold - nleft
+
+	left.setHeader(old.btype(), nleft)
+	right.setHeader(old.btype(), nright)
+	nodeAppendRange(left, old, 0, 0, nleft)
+	nodeAppendRange(right, old, 0, nleft, nright)
+
+	if old.isCompressed() {
+		// left/right just inherited old's anchor-relative prefix lengths,
+		// but right in particular no longer starts on that anchor's key,
+		// so its entries may no longer share as long a prefix with it as
+		// they would with their own first key. rebuildCompressed re-anchors
+		// each half on its own idx 0, which is what keeps compression
+		// effective as the tree grows instead of degrading every time a
+		// node is carved out of a larger, more-distant-anchored one.
+		//
+		// Re-anchoring can never make a node's *logical* contents bigger
+		// than packing them against a nearer anchor would (worst case,
+		// prefixLen drops to 0 and it costs 2 extra header bytes per
+		// entry versus the plain layout), but guard the copy anyway: if it
+		// somehow doesn't fit back in the buffer nodeAppendRange already
+		// sized and filled above, just keep that still-valid copy instead
+		// of truncating a larger one into it.
+		if rebuilt := rebuildCompressed(left[:left.nbytes()]); len(rebuilt) <= len(left) {
+			copy(left, rebuilt)
+		}
+		if rebuilt := rebuildCompressed(right[:right.nbytes()]); len(rebuilt) <= len(right) {
+			copy(right, rebuilt)
+		}
+	}
+}
+
+// replace one child pointer in a parent node with 1, 2, or 3 new child
+// pointers (the result of splitting that child), allocating pages for them
+func nodeReplaceKidN(tree *BTREE, new BNode, old BNode, idx uint16, kids ...BNode) {
+	inc := uint16(len(kids))
+	new.setHeader(BNODE_NODE, old.nkeys()+inc-1)
+	nodeAppendRange(new, old, 0, 0, idx)
+	for i, kid := range kids {
+		nodeAppendKV(new, idx+uint16(i), tree.new(kid), kid.getKey(0), nil)
+	}
+	nodeAppendRange(new, old, idx+inc, idx+1, old.nkeys()-(idx+1))
+}
+
+// treeDelete recursively removes key under node, returning the replacement
+// node, or nil if the key was not found.
+func treeDelete(tree *BTREE, node BNode, key []byte) BNode {
+	idx := nodeLookupLE(node, key)
+	switch node.kind() {
+	case BNODE_LEAF:
+		if !bytes.Equal(key, node.getKey(idx)) {
+			return nil
+		}
+		new := BNode(make([]byte, BTREE_PAGE_SIZE))
+		leafDelete(new, node, idx)
+		return new
+	case BNODE_NODE:
+		return nodeDelete(tree, node, idx, key)
+	default:
+		panic("bad node")
+	}
+}
+
+func leafDelete(new BNode, old BNode, idx uint16) {
+	new.setHeader(old.btype(), old.nkeys()-1) // preserves BNODE_COMPRESSED
+	nodeAppendRange(new, old, 0, 0, idx)
+	nodeAppendRange(new, old, idx, idx+1, old.nkeys()-idx-1)
+}
+
+// delete a key from a kid, then merge/redistribute it into a sibling if it
+// has shrunk below BTREE_MIN_FILL_SIZE
+func nodeDelete(tree *BTREE, node BNode, idx uint16, key []byte) BNode {
+	kptr := node.getPtr(idx)
+	updated := treeDelete(tree, BNode(tree.get(kptr)), key)
+	if updated == nil {
+		return nil // key not found in the subtree
+	}
+	tree.del(kptr)
+
+	new := BNode(make([]byte, BTREE_PAGE_SIZE))
+	mergeDir, sibling := shouldMerge(tree, node, idx, updated)
+	merged := BNode(make([]byte, BTREE_PAGE_SIZE))
+	switch {
+	case mergeDir < 0 && nodeMerge(merged, sibling, updated): // merged with left sibling
+		tree.del(node.getPtr(idx - 1))
+		nodeReplace2Kid(new, node, idx-1, tree.new(merged), merged.getKey(0))
+	case mergeDir > 0 && nodeMerge(merged, updated, sibling): // merged with right sibling
+		tree.del(node.getPtr(idx + 1))
+		nodeReplace2Kid(new, node, idx, tree.new(merged), merged.getKey(0))
+	case mergeDir == 0 && updated.nkeys() == 0:
+		assert(node.nkeys() == 1 && idx == 0) // only child, now empty
+		new.setHeader(BNODE_NODE, 0)          // caller (Delete) shrinks the tree
+	default: // no merge necessary, or the merge candidate didn't fit once
+		// re-anchored (see nodeMerge) -- just keep the under-full child
+		nodeReplaceKidN(tree, new, node, idx, updated)
+	}
+	return new
+}
+
+// nodeMerge concatenates left and right into new, reporting whether the
+// result fits in one page. For a compressed pair, nodeAppendRange anchors
+// every entry it writes on whichever key ends up at the merged node's own
+// idx 0 -- left's first key, since left is appended first -- so right's
+// entries, compressed against their own former (likely much nearer) anchor,
+// get re-encoded against left's instead. If left and right don't share much
+// of a prefix (e.g. adjacent leaves keyed "A..." and "B..."), that can cost
+// every right-hand entry close to its full key length instead of a short
+// suffix, and the merged result can overflow a page that the pre-merge
+// sizes suggested would fit (see shouldMerge's cheap pre-filter).
+//
+// The scratch buffer has to be sized for that worst case rather than a
+// fixed constant: left/right's own *compressed* sizes are no guide at all
+// to how big they get under a shared, possibly far-off anchor (a node with
+// a very favorable anchor can pack far more than one page's worth of
+// plain-equivalent content). Bound it instead by the two sides' actual
+// (decompressed) content, plus the at-most-2-bytes-per-entry a compressed
+// encoding can ever cost over the plain one (zero shared prefix, full key
+// stored as "suffix") -- that's always enough room, however extreme the
+// compression ratio, so building the merge can never itself panic; only
+// the final fit-in-one-page check below can reject it.
+func nodeMerge(new BNode, left BNode, right BNode) bool {
+	nkeys := left.nkeys() + right.nkeys()
+	bufSize := HEADER + int(nkeys)*10 + plainContentSize(left) + plainContentSize(right)
+	if bufSize < 2*BTREE_PAGE_SIZE {
+		bufSize = 2 * BTREE_PAGE_SIZE
+	}
+
+	scratch := BNode(make([]byte, bufSize))
+	scratch.setHeader(left.btype(), nkeys)
+	nodeAppendRange(scratch, left, 0, 0, left.nkeys())
+	nodeAppendRange(scratch, right, left.nkeys(), 0, right.nkeys())
+	scratch = scratch[:scratch.nbytes()]
+
+	if len(scratch) > len(new) {
+		return false
+	}
+	copy(new, scratch)
+	return true
+}
+
+// plainContentSize sums each entry's cost under the plain (uncompressed)
+// KV layout -- 4 bytes of lengths plus the full key and val -- regardless
+// of how node itself currently encodes them.
+func plainContentSize(node BNode) int {
+	total := 0
+	for i := uint16(0); i < node.nkeys(); i++ {
+		total += 4 + len(node.getKey(i)) + len(node.getVal(i))
+	}
+	return total
+}
+
+// replace two adjacent child pointers (idx, idx+1) with the single merged one
+func nodeReplace2Kid(new BNode, old BNode, idx uint16, mergedPtr uint64, mergedKey []byte) {
+	new.setHeader(BNODE_NODE, old.nkeys()-1)
+	nodeAppendRange(new, old, 0, 0, idx)
+	nodeAppendKV(new, idx, mergedPtr, mergedKey, nil)
+	nodeAppendRange(new, old, idx+1, idx+2, old.nkeys()-(idx+2))
+}
+
+// shouldMerge decides whether `updated` (the just-shrunk child at idx)
+// should be merged into a sibling, mirroring bbolt's node.rebalance: a node
+// below a quarter page is a merge candidate, and we prefer whichever
+// neighbor keeps the result within one page. This is only a cheap
+// pre-filter on the two sides' already-encoded sizes -- for a compressed
+// pair it can't account for re-anchoring growing the merged size past
+// BTREE_PAGE_SIZE, so nodeMerge re-checks the real, rebuilt size and
+// reports back if the merge it picked doesn't actually fit.
+func shouldMerge(tree *BTREE, node BNode, idx uint16, updated BNode) (int, BNode) {
+	if updated.nbytes() > BTREE_MIN_FILL_SIZE {
+		return 0, BNode{}
+	}
+
+	if idx > 0 {
+		sibling := BNode(tree.get(node.getPtr(idx - 1)))
+		merged := sibling.nbytes() + updated.nbytes() - HEADER
+		if merged <= BTREE_PAGE_SIZE {
+			return -1, sibling
+		}
+	}
+	if idx+1 < node.nkeys() {
+		sibling := BNode(tree.get(node.getPtr(idx + 1)))
+		merged := sibling.nbytes() + updated.nbytes() - HEADER
+		if merged <= BTREE_PAGE_SIZE {
+			return +1, sibling
+		}
+	}
+	return 0, BNode{}
+}
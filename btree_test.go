@@ -0,0 +1,202 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+// container wraps a BTREE with an in-memory page store and a reference map,
+// so tests can check the tree's behavior against a plain Go map.
+type container struct {
+	tree  BTREE
+	ref   map[string]string
+	pages map[uint64]BNode
+}
+
+func newContainer() *container {
+	pages := map[uint64]BNode{}
+	c := &container{
+		ref:   map[string]string{},
+		pages: pages,
+	}
+	c.tree.get = func(ptr uint64) []byte {
+		node, ok := pages[ptr]
+		if !ok {
+			panic(fmt.Sprintf("page %d not found", ptr))
+		}
+		return node
+	}
+	c.tree.new = func(node []byte) uint64 {
+		if BNode(node).nbytes() > BTREE_PAGE_SIZE {
+			panic("node too large to persist")
+		}
+		ptr := uint64(len(pages)) + 1
+		for pages[ptr] != nil {
+			ptr++
+		}
+		pages[ptr] = node
+		return ptr
+	}
+	c.tree.del = func(ptr uint64) {
+		if _, ok := pages[ptr]; !ok {
+			panic(fmt.Sprintf("freeing unknown page %d", ptr))
+		}
+		delete(pages, ptr)
+	}
+	return c
+}
+
+func (c *container) add(key, val string) {
+	c.tree.Insert([]byte(key), []byte(val))
+	c.ref[key] = val
+}
+
+func (c *container) del(key string) bool {
+	delete(c.ref, key)
+	return c.tree.Delete([]byte(key))
+}
+
+func (c *container) get(key string) (string, bool) {
+	val, ok := c.tree.Get([]byte(key))
+	return string(val), ok
+}
+
+func TestBTreeInsertGet(t *testing.T) {
+	c := newContainer()
+	for i := 0; i < 2000; i++ {
+		key := fmt.Sprintf("key%d", i)
+		val := fmt.Sprintf("val%d", i)
+		c.add(key, val)
+	}
+	for i := 0; i < 2000; i++ {
+		key := fmt.Sprintf("key%d", i)
+		val, ok := c.get(key)
+		if !ok || val != fmt.Sprintf("val%d", i) {
+			t.Fatalf("missing or wrong value for %q: got %q, ok=%v", key, val, ok)
+		}
+	}
+}
+
+// TestBTreeGetKeyThatIsPrefixOfAnother is a regression test for a bug in
+// nodeLookupLEFast's key packing: a key and a longer key sharing it as a
+// zero-padded prefix (e.g. "x" and "x\x00") used to pack to the same
+// uint64, making the fast path treat them as equal and Get lose track of
+// one of them.
+func TestBTreeGetKeyThatIsPrefixOfAnother(t *testing.T) {
+	c := newContainer()
+	c.add("x", "first")
+	c.add("x\x00", "second")
+
+	val, ok := c.get("x")
+	if !ok || val != "first" {
+		t.Fatalf(`Get("x") = %q, ok=%v; want "first", true`, val, ok)
+	}
+	val, ok = c.get("x\x00")
+	if !ok || val != "second" {
+		t.Fatalf(`Get("x\x00") = %q, ok=%v; want "second", true`, val, ok)
+	}
+}
+
+func TestBTreeUpdate(t *testing.T) {
+	c := newContainer()
+	c.add("k", "v1")
+	c.add("k", "v2")
+	val, ok := c.get("k")
+	if !ok || val != "v2" {
+		t.Fatalf("update did not take effect, got %q ok=%v", val, ok)
+	}
+}
+
+func TestBTreeRandomInsertDelete(t *testing.T) {
+	c := newContainer()
+	rng := rand.New(rand.NewSource(1))
+
+	const n = 3000
+	for i := 0; i < n; i++ {
+		key := fmt.Sprintf("key-%06d", rng.Intn(n/2))
+		val := fmt.Sprintf("val-%d", i)
+		if rng.Intn(4) == 0 && len(c.ref) > 0 {
+			// delete a random existing key instead of inserting
+			for k := range c.ref {
+				key = k
+				break
+			}
+			c.del(key)
+			continue
+		}
+		c.add(key, val)
+	}
+
+	for key, want := range c.ref {
+		got, ok := c.get(key)
+		if !ok || got != want {
+			t.Fatalf("mismatch for %q: want %q got %q ok=%v", key, want, got, ok)
+		}
+	}
+
+	// delete everything and check the tree empties out cleanly: only the
+	// root's sentinel empty-key entry survives, in a single leaf page.
+	for key := range c.ref {
+		if !c.del(key) {
+			t.Fatalf("failed to delete existing key %q", key)
+		}
+	}
+	root := BNode(c.pages[c.tree.root])
+	if root.kind() != BNODE_LEAF || root.nkeys() != 1 {
+		t.Fatalf("expected root to be a 1-key leaf (the sentinel) after deleting all keys, got type=%d nkeys=%d", root.btype(), root.nkeys())
+	}
+	if len(c.pages) != 1 {
+		t.Fatalf("expected a single remaining page after deleting all keys, got %d left", len(c.pages))
+	}
+}
+
+// TestBTreeDeleteMergesAcrossPrefixBoundary is an end-to-end regression test
+// (BTREE.Insert/Delete only, no internal node access) for a nodeMerge bug:
+// deleting keys out of one group of a compound/index-key workload -- long
+// shared prefix within a group, little shared prefix across groups, e.g.
+// "A-..." rows next to "B-..." rows -- used to drive a merge across that
+// boundary and panic with a slice-bounds overflow once the merged node,
+// re-encoded under one anchor, turned out not to fit a page.
+func TestBTreeDeleteMergesAcrossPrefixBoundary(t *testing.T) {
+	c := newContainer()
+	groups := []string{"A", "B", "C"}
+	const perGroup = 80
+	for _, g := range groups {
+		keys, vals := groupKeys(t, g, perGroup)
+		for i, k := range keys {
+			c.add(string(k), string(vals[i]))
+		}
+	}
+
+	// delete most of the middle group: its leaf shrinks below
+	// BTREE_MIN_FILL_SIZE and becomes a merge candidate against a
+	// neighboring leaf from a different, barely-overlapping prefix group.
+	keys, _ := groupKeys(t, "B", perGroup)
+	for _, k := range keys[:perGroup-2] {
+		if !c.del(string(k)) {
+			t.Fatalf("failed to delete existing key %q", k)
+		}
+	}
+
+	for key, want := range c.ref {
+		got, ok := c.get(key)
+		if !ok || got != want {
+			t.Fatalf("mismatch for %q: want %q got %q ok=%v", key, want, got, ok)
+		}
+	}
+}
+
+func TestBTreeDeleteMissing(t *testing.T) {
+	c := newContainer()
+	c.add("a", "1")
+	if c.del("does-not-exist") {
+		t.Fatalf("delete of missing key should report false")
+	}
+	if !c.del("a") {
+		t.Fatalf("delete of existing key should report true")
+	}
+	if c.del("a") {
+		t.Fatalf("second delete of the same key should report false")
+	}
+}
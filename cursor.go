@@ -0,0 +1,200 @@
+package main
+
+import "bytes"
+
+// Cursor is a bidirectional iterator over a BTREE's keys in sorted order.
+// It keeps a stack of (node, idx) frames from root to the current leaf, so
+// Next/Prev can step to an adjacent key without re-walking the tree from
+// the root each time.
+type Cursor struct {
+	tree  *BTREE
+	stack []cursorFrame
+}
+
+type cursorFrame struct {
+	node BNode
+	idx  uint16
+}
+
+// Cursor returns a new, unpositioned cursor over tree. Call Seek, First, or
+// Last before Next/Prev.
+func (tree *BTREE) Cursor() *Cursor {
+	return &Cursor{tree: tree}
+}
+
+// First positions the cursor at the smallest key and reports whether the
+// tree has any entries.
+func (c *Cursor) First() bool {
+	c.stack = c.stack[:0]
+	if c.tree.root == 0 {
+		return false
+	}
+	c.descendLeftmost(c.tree.root)
+	return c.skipLeadingSentinel()
+}
+
+// Last positions the cursor at the largest key and reports whether the
+// tree has any entries.
+func (c *Cursor) Last() bool {
+	c.stack = c.stack[:0]
+	if c.tree.root == 0 {
+		return false
+	}
+	c.descendRightmost(c.tree.root)
+	if c.atSentinel() {
+		c.stack = c.stack[:0]
+		return false
+	}
+	return true
+}
+
+// Seek positions the cursor at the smallest key >= key, reporting whether
+// such a key exists.
+func (c *Cursor) Seek(key []byte) bool {
+	if len(key) == 0 {
+		return c.First()
+	}
+
+	c.stack = c.stack[:0]
+	if c.tree.root == 0 {
+		return false
+	}
+
+	node := BNode(c.tree.get(c.tree.root))
+	for {
+		idx := nodeLookupLE(node, key)
+		c.stack = append(c.stack, cursorFrame{node, idx})
+		if node.kind() == BNODE_LEAF {
+			break
+		}
+		node = BNode(c.tree.get(node.getPtr(idx)))
+	}
+
+	if bytes.Compare(c.currentKey(), key) < 0 {
+		// nodeLookupLE landed on the largest key <= target (or the empty
+		// sentinel); step forward to the first key >= target.
+		if !c.stepForward() {
+			c.stack = c.stack[:0]
+			return false
+		}
+	}
+	if c.atSentinel() {
+		c.stack = c.stack[:0]
+		return false
+	}
+	return true
+}
+
+// Next advances to the next key in sorted order.
+func (c *Cursor) Next() (k []byte, v []byte, ok bool) {
+	if !c.stepForward() {
+		c.stack = c.stack[:0]
+		return nil, nil, false
+	}
+	return c.keyval()
+}
+
+// Prev retreats to the previous key in sorted order.
+func (c *Cursor) Prev() (k []byte, v []byte, ok bool) {
+	if !c.stepBackward() {
+		c.stack = c.stack[:0]
+		return nil, nil, false
+	}
+	if c.atSentinel() {
+		c.stack = c.stack[:0]
+		return nil, nil, false
+	}
+	return c.keyval()
+}
+
+func (c *Cursor) keyval() ([]byte, []byte, bool) {
+	node, idx := c.top()
+	return node.getKey(idx), node.getVal(idx), true
+}
+
+func (c *Cursor) top() (BNode, uint16) {
+	f := c.stack[len(c.stack)-1]
+	return f.node, f.idx
+}
+
+func (c *Cursor) currentKey() []byte {
+	node, idx := c.top()
+	return node.getKey(idx)
+}
+
+// atSentinel reports whether the cursor sits on the empty-key entry that
+// Insert seeds every fresh root with (see BTREE.Insert): real keys are
+// never empty, so it never denotes user data.
+func (c *Cursor) atSentinel() bool {
+	return len(c.stack) > 0 && len(c.currentKey()) == 0
+}
+
+func (c *Cursor) skipLeadingSentinel() bool {
+	if !c.atSentinel() {
+		return true
+	}
+	if !c.stepForward() {
+		c.stack = c.stack[:0]
+		return false
+	}
+	return true
+}
+
+// descendLeftmost pushes frames from pgid down to its leftmost leaf.
+func (c *Cursor) descendLeftmost(pgid uint64) {
+	node := BNode(c.tree.get(pgid))
+	for {
+		c.stack = append(c.stack, cursorFrame{node, 0})
+		if node.kind() == BNODE_LEAF {
+			return
+		}
+		node = BNode(c.tree.get(node.getPtr(0)))
+	}
+}
+
+// descendRightmost pushes frames from pgid down to its rightmost leaf.
+func (c *Cursor) descendRightmost(pgid uint64) {
+	node := BNode(c.tree.get(pgid))
+	for {
+		idx := node.nkeys() - 1
+		c.stack = append(c.stack, cursorFrame{node, idx})
+		if node.kind() == BNODE_LEAF {
+			return
+		}
+		node = BNode(c.tree.get(node.getPtr(idx)))
+	}
+}
+
+// stepForward advances the top-of-stack index, popping up and descending
+// back down into the next sibling subtree whenever the current frame is
+// exhausted.
+func (c *Cursor) stepForward() bool {
+	for len(c.stack) > 0 {
+		top := &c.stack[len(c.stack)-1]
+		if top.idx+1 < top.node.nkeys() {
+			top.idx++
+			if top.node.kind() != BNODE_LEAF {
+				c.descendLeftmost(top.node.getPtr(top.idx))
+			}
+			return true
+		}
+		c.stack = c.stack[:len(c.stack)-1]
+	}
+	return false
+}
+
+// stepBackward is the mirror image of stepForward.
+func (c *Cursor) stepBackward() bool {
+	for len(c.stack) > 0 {
+		top := &c.stack[len(c.stack)-1]
+		if top.idx > 0 {
+			top.idx--
+			if top.node.kind() != BNODE_LEAF {
+				c.descendRightmost(top.node.getPtr(top.idx))
+			}
+			return true
+		}
+		c.stack = c.stack[:len(c.stack)-1]
+	}
+	return false
+}
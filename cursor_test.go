@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"testing"
+)
+
+func buildCursorTestTree(t *testing.T, n int) (*BTREE, []string) {
+	t.Helper()
+	c := newContainer()
+	keys := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		key := fmt.Sprintf("key-%05d", i)
+		c.add(key, "v"+key)
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return &c.tree, keys
+}
+
+func TestCursorForwardIteration(t *testing.T) {
+	tree, keys := buildCursorTestTree(t, 500)
+
+	cur := tree.Cursor()
+	var got []string
+	ok := cur.First()
+	for ok {
+		node, idx := cur.top()
+		got = append(got, string(node.getKey(idx)))
+		_, _, ok = cur.Next()
+	}
+
+	if len(got) != len(keys) {
+		t.Fatalf("got %d keys, want %d", len(got), len(keys))
+	}
+	for i := range keys {
+		if got[i] != keys[i] {
+			t.Fatalf("at %d: got %q want %q", i, got[i], keys[i])
+		}
+	}
+}
+
+func TestCursorBackwardIteration(t *testing.T) {
+	tree, keys := buildCursorTestTree(t, 500)
+
+	cur := tree.Cursor()
+	var got []string
+	ok := cur.Last()
+	for ok {
+		node, idx := cur.top()
+		got = append(got, string(node.getKey(idx)))
+		_, _, ok = cur.Prev()
+	}
+
+	if len(got) != len(keys) {
+		t.Fatalf("got %d keys, want %d", len(got), len(keys))
+	}
+	for i := range keys {
+		if got[i] != keys[len(keys)-1-i] {
+			t.Fatalf("at %d: got %q want %q", i, got[i], keys[len(keys)-1-i])
+		}
+	}
+}
+
+func TestCursorSeek(t *testing.T) {
+	tree, keys := buildCursorTestTree(t, 200)
+
+	cur2 := tree.Cursor()
+	if !cur2.Seek([]byte(keys[50])) {
+		t.Fatalf("Seek exact match failed")
+	}
+	node, idx := cur2.top()
+	if string(node.getKey(idx)) != keys[50] {
+		t.Fatalf("Seek landed on %q, want %q", node.getKey(idx), keys[50])
+	}
+
+	// seek to a key between two real keys: should land on the next one up
+	between := keys[50] + "x"
+	cur3 := tree.Cursor()
+	if !cur3.Seek([]byte(between)) {
+		t.Fatalf("Seek(between) found nothing")
+	}
+	node3, idx3 := cur3.top()
+	if string(node3.getKey(idx3)) != keys[51] {
+		t.Fatalf("Seek(between) landed on %q, want %q", node3.getKey(idx3), keys[51])
+	}
+
+	// seek past the end
+	cur4 := tree.Cursor()
+	if cur4.Seek([]byte("zzzzzzzzzz")) {
+		t.Fatalf("Seek past the last key should report no match")
+	}
+}
+
+func TestCursorEmptyTree(t *testing.T) {
+	c := newContainer()
+	cur := c.tree.Cursor()
+	if cur.First() {
+		t.Fatalf("First() on empty tree should report false")
+	}
+	if cur.Last() {
+		t.Fatalf("Last() on empty tree should report false")
+	}
+	if cur.Seek([]byte("x")) {
+		t.Fatalf("Seek() on empty tree should report false")
+	}
+}
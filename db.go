@@ -0,0 +1,196 @@
+package main
+
+import (
+	"errors"
+	"sync"
+)
+
+// DB layers MVCC transactions on top of a Pager: writers are serialized one
+// at a time (as in bbolt), while readers run concurrently against an
+// immutable snapshot of the tree. A page a writer replaces is only handed
+// back to the pager's free-list once no reader could still be traversing
+// it, by tagging it with the commit's txid and only reusing it once every
+// open reader's snapshot txid has moved past that.
+type DB struct {
+	pager *Pager
+
+	writeMu sync.Mutex // held for the duration of a write Tx
+
+	metaMu sync.RWMutex // guards txn/root, published atomically on commit
+	txn    uint64
+	root   uint64
+
+	readersMu sync.Mutex
+	readers   map[uint64]int // reader snapshot txid -> count of Txs holding it
+}
+
+// OpenDB opens (creating if necessary) a transactional store at path.
+func OpenDB(path string, pageSize int) (*DB, error) {
+	pager, err := OpenPager(path, pageSize)
+	if err != nil {
+		return nil, err
+	}
+	return &DB{
+		pager:   pager,
+		txn:     pager.txn,
+		root:    pager.root,
+		readers: map[uint64]int{},
+	}, nil
+}
+
+// Close closes the underlying pager. Callers must ensure no Tx is open.
+func (db *DB) Close() error {
+	return db.pager.Close()
+}
+
+// Tx is a read or read-write transaction against a consistent snapshot of
+// the tree.
+type Tx struct {
+	db       *DB
+	writable bool
+	txid     uint64 // snapshot txid for a reader; the commit txid for a writer
+	tree     *BTREE
+	done     bool
+
+	allocated []uint64 // pages newly allocated by this write Tx
+	freed     []uint64 // pre-image pages this write Tx made unreachable
+}
+
+// Begin starts a transaction. A writable Tx is exclusive with other writers
+// (Begin blocks until the previous write Tx commits or rolls back); a
+// read-only Tx never blocks and never blocks a concurrent writer.
+func (db *DB) Begin(writable bool) (*Tx, error) {
+	if writable {
+		db.writeMu.Lock()
+	}
+
+	var txid, root uint64
+	if writable {
+		db.metaMu.RLock()
+		txid, root = db.txn+1, db.root
+		db.metaMu.RUnlock()
+	} else {
+		// The snapshot read and the reader registration below must be one
+		// atomic step: if a writer's Alloc -> oldestReader() ran in the
+		// gap between them, it would see no reader registered yet and
+		// could judge a page this snapshot depends on safe to recycle,
+		// freeing it before this reader ever gets to read it. Taking
+		// readersMu before metaMu (the same order oldestReader uses)
+		// makes "read the snapshot, then register it" indivisible from a
+		// concurrent oldestReader's point of view.
+		db.readersMu.Lock()
+		db.metaMu.RLock()
+		txid, root = db.txn, db.root
+		db.metaMu.RUnlock()
+		db.readers[txid]++
+		db.readersMu.Unlock()
+	}
+
+	tx := &Tx{db: db, writable: writable, txid: txid}
+	if writable {
+		tx.tree = &BTREE{root: root, get: db.pager.Get, new: tx.allocPage, del: tx.freePage}
+	} else {
+		tx.tree = &BTREE{root: root, get: db.pager.Get, new: refuseAlloc, del: refuseFree}
+	}
+	return tx, nil
+}
+
+func refuseAlloc([]byte) uint64 { panic("database: read-only transaction cannot allocate pages") }
+func refuseFree(uint64)         { panic("database: read-only transaction cannot free pages") }
+
+func (tx *Tx) allocPage(data []byte) uint64 {
+	pgid := tx.db.pager.Alloc(data, tx.db.oldestReader())
+	tx.allocated = append(tx.allocated, pgid)
+	return pgid
+}
+
+func (tx *Tx) freePage(pgid uint64) {
+	tx.freed = append(tx.freed, pgid)
+}
+
+// Bucket returns the tree this Tx reads or writes. Named after bbolt's
+// Tx.Bucket; this store has only the one top-level tree so far, so it
+// always returns the same BTREE.
+func (tx *Tx) Bucket() *BTREE {
+	return tx.tree
+}
+
+// Commit publishes a write Tx's root as the new snapshot, fsync'ing the
+// data pages before the meta page that points at them so a crash can never
+// observe a root that isn't fully durable. Pre-image pages this Tx
+// replaced are returned to the free-list tagged with the commit's txid, so
+// they won't be reused while an older reader might still be traversing
+// them.
+func (tx *Tx) Commit() error {
+	if tx.done {
+		return errors.New("database: transaction already closed")
+	}
+	if !tx.writable {
+		return errors.New("database: cannot commit a read-only transaction")
+	}
+	tx.done = true
+	defer tx.db.writeMu.Unlock()
+
+	for _, pgid := range tx.freed {
+		tx.db.pager.Free(pgid, tx.txid)
+	}
+
+	tx.db.pager.SetRoot(tx.tree.root)
+	if err := tx.db.pager.Sync(); err != nil {
+		return err
+	}
+
+	tx.db.metaMu.Lock()
+	tx.db.root = tx.tree.root
+	tx.db.txn = tx.txid
+	tx.db.metaMu.Unlock()
+	return nil
+}
+
+// Rollback discards a write Tx's changes, or releases a read Tx's snapshot.
+// A write Tx's newly allocated pages were never published, so they're
+// immediately safe to free; its pre-image pages are left alone since the
+// (still current) old root still owns them.
+func (tx *Tx) Rollback() error {
+	if tx.done {
+		return nil
+	}
+	tx.done = true
+	if tx.writable {
+		defer tx.db.writeMu.Unlock()
+		for _, pgid := range tx.allocated {
+			tx.db.pager.Free(pgid, 0)
+		}
+	} else {
+		tx.db.removeReader(tx.txid)
+	}
+	return nil
+}
+
+func (db *DB) removeReader(txid uint64) {
+	db.readersMu.Lock()
+	db.readers[txid]--
+	if db.readers[txid] <= 0 {
+		delete(db.readers, txid)
+	}
+	db.readersMu.Unlock()
+}
+
+// oldestReader returns the lowest snapshot txid any open reader still
+// holds, or the next commit's txid if there are none (meaning every freed
+// page is immediately reusable).
+func (db *DB) oldestReader() uint64 {
+	db.readersMu.Lock()
+	defer db.readersMu.Unlock()
+
+	db.metaMu.RLock()
+	oldest := db.txn + 1
+	db.metaMu.RUnlock()
+
+	for txid := range db.readers {
+		if txid < oldest {
+			oldest = txid
+		}
+	}
+	return oldest
+}
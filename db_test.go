@@ -0,0 +1,260 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func openTestDB(t *testing.T) *DB {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "test.db")
+	db, err := OpenDB(path, BTREE_PAGE_SIZE)
+	if err != nil {
+		t.Fatalf("OpenDB: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestDBWriteThenReadCommitted(t *testing.T) {
+	db := openTestDB(t)
+
+	wtx, err := db.Begin(true)
+	if err != nil {
+		t.Fatalf("Begin(true): %v", err)
+	}
+	wtx.Bucket().Insert([]byte("a"), []byte("1"))
+	if err := wtx.Commit(); err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+
+	rtx, err := db.Begin(false)
+	if err != nil {
+		t.Fatalf("Begin(false): %v", err)
+	}
+	defer rtx.Rollback()
+	val, ok := rtx.Bucket().Get([]byte("a"))
+	if !ok || string(val) != "1" {
+		t.Fatalf("expected committed value, got %q ok=%v", val, ok)
+	}
+}
+
+func TestDBRollbackDiscardsChanges(t *testing.T) {
+	db := openTestDB(t)
+
+	wtx, _ := db.Begin(true)
+	wtx.Bucket().Insert([]byte("a"), []byte("1"))
+	if err := wtx.Rollback(); err != nil {
+		t.Fatalf("Rollback: %v", err)
+	}
+
+	rtx, _ := db.Begin(false)
+	defer rtx.Rollback()
+	if _, ok := rtx.Bucket().Get([]byte("a")); ok {
+		t.Fatalf("rolled-back write should not be visible")
+	}
+}
+
+func TestDBReaderSeesConsistentSnapshotDuringWrite(t *testing.T) {
+	db := openTestDB(t)
+
+	setup, _ := db.Begin(true)
+	setup.Bucket().Insert([]byte("a"), []byte("1"))
+	if err := setup.Commit(); err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+
+	reader, err := db.Begin(false)
+	if err != nil {
+		t.Fatalf("Begin(false): %v", err)
+	}
+	defer reader.Rollback()
+
+	writer, _ := db.Begin(true)
+	writer.Bucket().Insert([]byte("a"), []byte("2"))
+	writer.Bucket().Insert([]byte("b"), []byte("3"))
+	if err := writer.Commit(); err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+
+	// the reader's snapshot predates the second commit, so it must still
+	// see the old value and must not see the new key at all.
+	val, ok := reader.Bucket().Get([]byte("a"))
+	if !ok || string(val) != "1" {
+		t.Fatalf("reader snapshot changed under it: got %q ok=%v, want \"1\"", val, ok)
+	}
+	if _, ok := reader.Bucket().Get([]byte("b")); ok {
+		t.Fatalf("reader snapshot should not see a key committed after it began")
+	}
+
+	rtx2, _ := db.Begin(false)
+	defer rtx2.Rollback()
+	if val, ok := rtx2.Bucket().Get([]byte("a")); !ok || string(val) != "2" {
+		t.Fatalf("new reader should see the latest commit, got %q ok=%v", val, ok)
+	}
+}
+
+func TestDBDeferredFreeNotReusedWhileReaderOpen(t *testing.T) {
+	db := openTestDB(t)
+
+	setup, _ := db.Begin(true)
+	for i := 0; i < 200; i++ {
+		setup.Bucket().Insert([]byte(fmt.Sprintf("key-%04d", i)), []byte("v"))
+	}
+	if err := setup.Commit(); err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+
+	reader, err := db.Begin(false)
+	if err != nil {
+		t.Fatalf("Begin(false): %v", err)
+	}
+
+	mutate, _ := db.Begin(true)
+	for i := 0; i < 200; i++ {
+		mutate.Bucket().Delete([]byte(fmt.Sprintf("key-%04d", i)))
+	}
+	if err := mutate.Commit(); err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+
+	// the old reader must still be able to walk its snapshot: none of the
+	// pre-image pages it depends on may have been recycled yet.
+	for i := 0; i < 200; i++ {
+		val, ok := reader.Bucket().Get([]byte(fmt.Sprintf("key-%04d", i)))
+		if !ok || string(val) != "v" {
+			t.Fatalf("reader lost key-%04d after concurrent delete: ok=%v val=%q", i, ok, val)
+		}
+	}
+	if err := reader.Rollback(); err != nil {
+		t.Fatalf("Rollback: %v", err)
+	}
+}
+
+// TestDBCommitDoesNotLeakFreelistPages is a regression test for a bug where
+// the pager's free-list storage pages were never reclaimed: Tx.Commit calls
+// pager.Sync() on every commit, so a workload that frees as many pages as it
+// allocates (insert then delete the same key) still grew the file without
+// bound, purely from each commit abandoning the previous commit's free-list
+// pages.
+func TestDBCommitDoesNotLeakFreelistPages(t *testing.T) {
+	db := openTestDB(t)
+
+	// two warm-up commits: the first establishes the free-list's own
+	// storage pages, the second settles numPages once writeFreelist starts
+	// reusing rather than abandoning them.
+	for i := 0; i < 2; i++ {
+		setup, _ := db.Begin(true)
+		setup.Bucket().Insert([]byte("k"), []byte("v"))
+		setup.Bucket().Delete([]byte("k"))
+		if err := setup.Commit(); err != nil {
+			t.Fatalf("Commit: %v", err)
+		}
+	}
+
+	before := db.pager.numPages
+	for i := 0; i < 50; i++ {
+		tx, _ := db.Begin(true)
+		tx.Bucket().Insert([]byte("k"), []byte("v"))
+		tx.Bucket().Delete([]byte("k"))
+		if err := tx.Commit(); err != nil {
+			t.Fatalf("Commit: %v", err)
+		}
+	}
+	if db.pager.numPages != before {
+		t.Fatalf("repeated insert-then-delete leaked pages: numPages went %d -> %d", before, db.pager.numPages)
+	}
+}
+
+// TestDBConcurrentReadersSurviveWriterChurn runs actual goroutines (under
+// -race) racing Begin against a writer that continuously churns pages, to
+// exercise the gap between a reader's snapshot read and its registration
+// in DB.Begin: if those two steps aren't atomic, a writer's
+// Alloc -> oldestReader() running in between can see no reader registered
+// yet and recycle a page the snapshot still depends on.
+func TestDBConcurrentReadersSurviveWriterChurn(t *testing.T) {
+	db := openTestDB(t)
+
+	const nkeys = 200
+	setup, _ := db.Begin(true)
+	for i := 0; i < nkeys; i++ {
+		setup.Bucket().Insert([]byte(fmt.Sprintf("key-%04d", i)), []byte("v0"))
+	}
+	if err := setup.Commit(); err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+
+	// This long-lived reader's snapshot must survive the writer churn
+	// below for the reader's whole lifetime.
+	reader, err := db.Begin(false)
+	if err != nil {
+		t.Fatalf("Begin(false): %v", err)
+	}
+	defer reader.Rollback()
+
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+
+	// writer: repeatedly rewrites every key, which frees and reallocates
+	// pages the whole time the long-lived reader above stays open.
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for round := 0; ; round++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			tx, err := db.Begin(true)
+			if err != nil {
+				t.Errorf("Begin(true): %v", err)
+				return
+			}
+			for i := 0; i < nkeys; i++ {
+				tx.Bucket().Insert([]byte(fmt.Sprintf("key-%04d", i)), []byte(fmt.Sprintf("v%d", round+1)))
+			}
+			if err := tx.Commit(); err != nil {
+				t.Errorf("Commit: %v", err)
+				return
+			}
+		}
+	}()
+
+	// concurrent short-lived readers, racing Begin/addReader against the
+	// writer and each other the same way real concurrent clients would.
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				tx, err := db.Begin(false)
+				if err != nil {
+					t.Errorf("Begin(false): %v", err)
+					return
+				}
+				for k := 0; k < nkeys; k += 37 { // sample a few keys, keep it quick
+					tx.Bucket().Get([]byte(fmt.Sprintf("key-%04d", k)))
+				}
+				tx.Rollback()
+			}
+		}()
+	}
+
+	time.Sleep(200 * time.Millisecond)
+	close(stop)
+	wg.Wait()
+
+	// the long-lived reader's snapshot must be exactly as it was at
+	// Begin: every key still present with its original value, meaning
+	// none of its pre-image pages were recycled out from under it.
+	for i := 0; i < nkeys; i++ {
+		val, ok := reader.Bucket().Get([]byte(fmt.Sprintf("key-%04d", i)))
+		if !ok || string(val) != "v0" {
+			t.Fatalf("long-lived reader lost key-%04d under writer churn: ok=%v val=%q", i, ok, val)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import "encoding/binary"
+
+/*
+	Compressed node KV layout (selected by the BNODE_COMPRESSED bit in btype)
+
+	Entry 0 ("the anchor") is stored in full, in the same layout as a plain
+	node's entries:
+
+		| klen | vlen | key | val |
+		| 2B   |  2B  | ... | ... |
+
+	Every later entry stores only how it differs from the anchor: a prefix
+	length shared with the anchor's key, plus the remaining suffix bytes.
+
+		| prefixLen | suffixLen | vlen | suffix | val |
+		|    2B     |    2B     |  2B  |  ...   | ... |
+
+	Keys in a node are sorted ascending, and real-world keys (URLs, paths,
+	sorted IDs) tend to share long common prefixes, so anchoring every entry
+	against entry 0 instead of storing each key in full raises the number of
+	entries that fit in one BTREE_PAGE_SIZE page, i.e. the tree's fanout.
+
+	The offset table (offsetPos/getOffset/setOffset) and nbytes() above are
+	unaffected: they only track the byte length of each entry, not how its
+	contents are encoded, so they're shared verbatim between both layouts.
+*/
+
+// compressedPrefixSuffix reads the (prefixLen, suffix) pair stored for a
+// compressed entry. idx must be > 0 (idx 0 is always the anchor, stored via
+// rawKV).
+func (node BNode) compressedPrefixSuffix(idx uint16) (prefixLen uint16, suffix []byte) {
+	pos := node.kvPos(idx)
+	prefixLen = binary.LittleEndian.Uint16(node[pos:])
+	suffixLen := binary.LittleEndian.Uint16(node[pos+2:])
+	suffix = node[pos+6:][:suffixLen]
+	return prefixLen, suffix
+}
+
+func (node BNode) compressedVal(idx uint16) []byte {
+	pos := node.kvPos(idx)
+	suffixLen := binary.LittleEndian.Uint16(node[pos+2:])
+	vlen := binary.LittleEndian.Uint16(node[pos+4:])
+	return node[pos+6+suffixLen:][:vlen]
+}
+
+// nodeAppendKVCompressed writes entry idx (idx > 0) of a compressed node as
+// (prefixLen, suffixLen, vlen, suffix, val), where prefixLen/suffix are
+// computed against new's own anchor (idx 0, which must already have been
+// written). Called from nodeAppendKV's dispatch; ptr is set by the caller.
+func nodeAppendKVCompressed(new BNode, idx uint16, key []byte, val []byte) {
+	anchor, _ := new.rawKV(0)
+	prefixLen := uint16(commonPrefixLen(anchor, key))
+	suffix := key[prefixLen:]
+
+	pos := new.kvPos(idx)
+	binary.LittleEndian.PutUint16(new[pos+0:], prefixLen)
+	binary.LittleEndian.PutUint16(new[pos+2:], uint16(len(suffix)))
+	binary.LittleEndian.PutUint16(new[pos+4:], uint16(len(val)))
+	copy(new[pos+6:], suffix)
+	copy(new[pos+6+uint16(len(suffix)):], val)
+
+	new.setOffset(idx+1, new.getOffset(idx)+6+uint16(len(suffix)+len(val)))
+}
+
+// commonPrefixLen returns the length of the longest common prefix of a and b.
+func commonPrefixLen(a, b []byte) int {
+	n := len(a)
+	if len(b) < n {
+		n = len(b)
+	}
+	i := 0
+	for i < n && a[i] == b[i] {
+		i++
+	}
+	return i
+}
+
+// rebuildCompressed returns a copy of old re-encoded with the
+// prefix-compressed layout, anchored on old's own first key, regardless of
+// old's current encoding. nodeAppendRange re-anchors automatically as soon
+// as it writes the new node's idx 0 (see its dispatch above), so a split's
+// right-hand half ends up anchored on its own first key rather than an
+// ancestor's, which is what keeps compression effective as a tree grows:
+// callers that carve a node out of a larger one (e.g. nodeSplit2/3) should
+// run each half through this before using it, instead of inheriting the
+// original node's anchor verbatim.
+func rebuildCompressed(old BNode) BNode {
+	// The compressed layout is never larger than the plain one (every
+	// entry it replaces a full key with is at worst as long as the
+	// suffix+lengths it stores instead), so old's own size is always
+	// enough room to re-encode it, short of the minimum 2*BTREE_PAGE_SIZE
+	// scratch space plain nodes are built with elsewhere in this package.
+	bufSize := 2 * BTREE_PAGE_SIZE
+	if len(old) > bufSize {
+		bufSize = len(old)
+	}
+	new := BNode(make([]byte, bufSize))
+	new.setHeader(old.btype()|BNODE_COMPRESSED, old.nkeys())
+	nodeAppendRange(new, old, 0, 0, old.nkeys())
+	return new[:new.nbytes()]
+}
@@ -0,0 +1,301 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+// buildLeafPlain packs keys (sorted ascending, no duplicates) and dummy
+// values into a plain (uncompressed) leaf node.
+func buildLeafPlain(t *testing.T, keys [][]byte, vals [][]byte) BNode {
+	t.Helper()
+	node := BNode(make([]byte, 2*BTREE_PAGE_SIZE))
+	node.setHeader(BNODE_LEAF, uint16(len(keys)))
+	for i, k := range keys {
+		nodeAppendKV(node, uint16(i), 0, k, vals[i])
+	}
+	return node[:node.nbytes()]
+}
+
+func TestRebuildCompressedRoundTripsMatchPlainLayout(t *testing.T) {
+	rng := rand.New(rand.NewSource(11))
+
+	for trial := 0; trial < 300; trial++ {
+		n := rng.Intn(30) + 1
+		keys := make([][]byte, n)
+		vals := make([][]byte, n)
+		for i := range keys {
+			klen := rng.Intn(20) + 1
+			buf := make([]byte, klen)
+			rng.Read(buf)
+			keys[i] = buf
+			vlen := rng.Intn(10)
+			v := make([]byte, vlen)
+			rng.Read(v)
+			vals[i] = v
+		}
+		sortKeysAndVals(keys, vals)
+		keys, vals = dedupKeysAndVals(keys, vals)
+
+		plain := buildLeafPlain(t, keys, vals)
+		compressed := rebuildCompressed(plain)
+
+		if !compressed.isCompressed() {
+			t.Fatalf("rebuildCompressed did not set BNODE_COMPRESSED")
+		}
+		if compressed.nkeys() != plain.nkeys() {
+			t.Fatalf("nkeys mismatch: got %d want %d", compressed.nkeys(), plain.nkeys())
+		}
+		for i := uint16(0); i < plain.nkeys(); i++ {
+			if !bytes.Equal(compressed.getKey(i), plain.getKey(i)) {
+				t.Fatalf("key %d mismatch: got %x want %x", i, compressed.getKey(i), plain.getKey(i))
+			}
+			if !bytes.Equal(compressed.getVal(i), plain.getVal(i)) {
+				t.Fatalf("val %d mismatch: got %x want %x", i, compressed.getVal(i), plain.getVal(i))
+			}
+			if got := nodeLookupLE(compressed, plain.getKey(i)); got != i {
+				t.Fatalf("nodeLookupLE(compressed, %x) = %d, want %d", plain.getKey(i), got, i)
+			}
+		}
+	}
+}
+
+func TestGetKeyIntoReusesBuffer(t *testing.T) {
+	keys := [][]byte{[]byte("/a/b/c"), []byte("/a/b/charlie"), []byte("/a/b/delta")}
+	vals := [][]byte{[]byte("1"), []byte("2"), []byte("3")}
+	compressed := rebuildCompressed(buildLeafPlain(t, keys, vals))
+
+	var buf []byte
+	for i, want := range keys {
+		buf = compressed.getKeyInto(uint16(i), buf)
+		if !bytes.Equal(buf, want) {
+			t.Fatalf("getKeyInto(%d) = %q, want %q", i, buf, want)
+		}
+	}
+}
+
+func TestNodeAppendRangeCompressedPartial(t *testing.T) {
+	keys := [][]byte{[]byte("aaa"), []byte("aab"), []byte("aac"), []byte("aad")}
+	vals := [][]byte{[]byte("1"), []byte("2"), []byte("3"), []byte("4")}
+	old := rebuildCompressed(buildLeafPlain(t, keys, vals))
+
+	new := BNode(make([]byte, 2*BTREE_PAGE_SIZE))
+	new.setHeader(BNODE_LEAF|BNODE_COMPRESSED, 2)
+	nodeAppendRange(new, old, 0, 1, 2) // copy just old[1:3] into a fresh node
+	new = new[:new.nbytes()]
+
+	if !bytes.Equal(new.getKey(0), keys[1]) || !bytes.Equal(new.getKey(1), keys[2]) {
+		t.Fatalf("got keys %q, %q; want %q, %q", new.getKey(0), new.getKey(1), keys[1], keys[2])
+	}
+}
+
+func sortKeysAndVals(keys, vals [][]byte) {
+	idx := make([]int, len(keys))
+	for i := range idx {
+		idx[i] = i
+	}
+	for i := 1; i < len(idx); i++ {
+		for j := i; j > 0 && bytes.Compare(keys[idx[j-1]], keys[idx[j]]) > 0; j-- {
+			idx[j-1], idx[j] = idx[j], idx[j-1]
+		}
+	}
+	sortedKeys := make([][]byte, len(keys))
+	sortedVals := make([][]byte, len(keys))
+	for i, j := range idx {
+		sortedKeys[i] = keys[j]
+		sortedVals[i] = vals[j]
+	}
+	copy(keys, sortedKeys)
+	copy(vals, sortedVals)
+}
+
+func dedupKeysAndVals(keys, vals [][]byte) ([][]byte, [][]byte) {
+	dk := keys[:0]
+	dv := vals[:0]
+	for i := range keys {
+		if i == 0 || !bytes.Equal(keys[i], keys[i-1]) {
+			dk = append(dk, keys[i])
+			dv = append(dv, vals[i])
+		}
+	}
+	return dk, dv
+}
+
+// TestBTreeCompressedLeavesRaiseFanout exercises the feature end-to-end
+// through the public BTREE API (not just the standalone node helpers
+// above): it inserts/gets real URL-like keys through BTREE.Insert/Get,
+// which by default now build prefix-compressed leaves, and checks that
+// the resulting tree both works and actually uses fewer leaf pages than
+// an equivalent plain (uncompressed) tree would need.
+func TestBTreeCompressedLeavesRaiseFanout(t *testing.T) {
+	c := newContainer()
+	keys := makeURLKeys(600)
+	for i, k := range keys {
+		c.add(string(k), fmt.Sprintf("v%d", i))
+	}
+	for i, k := range keys {
+		val, ok := c.get(string(k))
+		if !ok || val != fmt.Sprintf("v%d", i) {
+			t.Fatalf("Get(%q) = %q, ok=%v; want v%d, true", k, val, ok, i)
+		}
+	}
+
+	sawCompressedLeaf := false
+	leafPages := 0
+	for _, page := range c.pages {
+		if page.kind() == BNODE_LEAF {
+			leafPages++
+			if page.isCompressed() {
+				sawCompressedLeaf = true
+			}
+		}
+	}
+	if !sawCompressedLeaf {
+		t.Fatalf("expected at least one compressed leaf page in a tree built via BTREE.Insert")
+	}
+
+	// How many of these same keys a plain (uncompressed) leaf could hold
+	// per BTREE_PAGE_SIZE page, to compare against the real tree's leaf
+	// page count above.
+	plainFanout := 0
+	for n := 1; n <= len(keys); n++ {
+		node := BNode(make([]byte, (n+1)*64+BTREE_PAGE_SIZE))
+		node.setHeader(BNODE_LEAF, uint16(n))
+		for i := 0; i < n; i++ {
+			nodeAppendKV(node, uint16(i), 0, keys[i], []byte(fmt.Sprintf("v%d", i)))
+		}
+		if node.nbytes() > BTREE_PAGE_SIZE {
+			break
+		}
+		plainFanout = n
+	}
+	plainPages := (len(keys) + plainFanout - 1) / plainFanout
+	if leafPages >= plainPages {
+		t.Fatalf("compression bought nothing in the real tree: used %d leaf pages, a plain tree would need ~%d (plain fanout %d/page)", leafPages, plainPages, plainFanout)
+	}
+	t.Logf("leaf pages in real tree: compressed=%d vs plain~=%d (plain fanout=%d/page)", leafPages, plainPages, plainFanout)
+}
+
+// TestNodeMergeReanchorsAcrossPrefixBoundary is a regression test for a bug
+// where nodeMerge kept left's anchor for the whole merged node, including
+// right's entries -- which were only ever compressed against their own
+// former anchor. Two groups of keys with a long shared prefix *within* each
+// group but almost none *across* the merge boundary ("A..." next to "B...")
+// used to balloon right's suffixes back out toward full length and panic
+// with a slice-bounds overflow once the merged node didn't actually fit.
+func TestNodeMergeReanchorsAcrossPrefixBoundary(t *testing.T) {
+	leftKeys, leftVals := groupKeys(t, "A", 40)
+	rightKeys, rightVals := groupKeys(t, "B", 40)
+	left := rebuildCompressed(buildLeafPlain(t, leftKeys, leftVals))
+	right := rebuildCompressed(buildLeafPlain(t, rightKeys, rightVals))
+
+	merged := BNode(make([]byte, BTREE_PAGE_SIZE))
+	if !nodeMerge(merged, left, right) {
+		t.Fatalf("expected a modest two-group merge to fit in one page")
+	}
+	want := append(append([][]byte{}, leftKeys...), rightKeys...)
+	for i, k := range want {
+		if got := merged.getKey(uint16(i)); !bytes.Equal(got, k) {
+			t.Fatalf("key %d: got %q want %q", i, got, k)
+		}
+	}
+}
+
+// TestNodeMergeRejectsOversizedResultInsteadOfPanicking drives the same
+// cross-group scenario with enough keys per side that, once re-anchored,
+// the merged node genuinely doesn't fit in a page -- even though each side
+// individually looked like a comfortable merge candidate under its own
+// (much shorter, within-group) compressed size. nodeMerge must report this
+// rather than silently overflowing its destination buffer.
+func TestNodeMergeRejectsOversizedResultInsteadOfPanicking(t *testing.T) {
+	leftKeys, leftVals := groupKeys(t, "A", 80)
+	rightKeys, rightVals := groupKeys(t, "B", 80)
+	left := rebuildCompressed(buildLeafPlain(t, leftKeys, leftVals))
+	right := rebuildCompressed(buildLeafPlain(t, rightKeys, rightVals))
+
+	// shouldMerge's cheap pre-filter only looks at the two sides' own
+	// (already-compressed) sizes; this setup is exactly the case where
+	// that filter says "fits" but the real, re-anchored merge doesn't.
+	if int(left.nbytes())+int(right.nbytes())-HEADER > BTREE_PAGE_SIZE {
+		t.Fatalf("test setup invariant broken: shouldMerge's pre-filter should accept this pair (left=%d right=%d)", left.nbytes(), right.nbytes())
+	}
+
+	merged := BNode(make([]byte, BTREE_PAGE_SIZE))
+	if nodeMerge(merged, left, right) {
+		t.Fatalf("expected the re-anchored merge to overflow a page and be rejected")
+	}
+}
+
+// groupKeys builds n keys sharing prefix+"-" and a long common run, varying
+// only in their last few bytes, so they compress well against each other
+// but barely at all against a different group's anchor.
+func groupKeys(t *testing.T, prefix string, n int) ([][]byte, [][]byte) {
+	t.Helper()
+	keys := make([][]byte, n)
+	vals := make([][]byte, n)
+	for i := range keys {
+		keys[i] = []byte(fmt.Sprintf("%s-%050d", prefix, i))
+		vals[i] = []byte("v")
+	}
+	return keys, vals
+}
+
+// makeURLKeys builds ascending, URL-like keys that share long common
+// prefixes, the case prefix compression is meant for.
+func makeURLKeys(n int) [][]byte {
+	keys := make([][]byte, n)
+	for i := range keys {
+		keys[i] = []byte(fmt.Sprintf("https://example.com/api/v1/users/%08d/profile", i))
+	}
+	return keys
+}
+
+// BenchmarkCompressedFanoutURLKeys reports how many URL-like keys fit in a
+// single BTREE_PAGE_SIZE page under each layout, i.e. the fanout gain from
+// prefix compression on realistically-prefixed data.
+func BenchmarkCompressedFanoutURLKeys(b *testing.B) {
+	// Capped well under the point where cumulative KV bytes would overflow
+	// the node format's uint16 byte offsets (this benchmark deliberately
+	// overshoots a single page's real fanout to binary-search for the
+	// boundary; it never needs to approach that limit).
+	keys := makeURLKeys(800)
+	val := []byte("v")
+
+	fits := func(build func(n int) BNode) int {
+		lo, hi := 0, len(keys)
+		for lo < hi {
+			mid := (lo + hi + 1) / 2
+			if len(build(mid)) <= BTREE_PAGE_SIZE {
+				lo = mid
+			} else {
+				hi = mid - 1
+			}
+		}
+		return lo
+	}
+
+	buildPlain := func(n int) BNode {
+		node := BNode(make([]byte, (n+1)*64+BTREE_PAGE_SIZE))
+		node.setHeader(BNODE_LEAF, uint16(n))
+		for i := 0; i < n; i++ {
+			nodeAppendKV(node, uint16(i), 0, keys[i], val)
+		}
+		return node[:node.nbytes()]
+	}
+	buildCompressed := func(n int) BNode {
+		return rebuildCompressed(buildPlain(n))
+	}
+
+	plainFanout := fits(buildPlain)
+	compressedFanout := fits(buildCompressed)
+	b.Logf("fanout per %dB page: plain=%d compressed=%d", BTREE_PAGE_SIZE, plainFanout, compressedFanout)
+	b.ReportMetric(float64(plainFanout), "plain_fanout")
+	b.ReportMetric(float64(compressedFanout), "compressed_fanout")
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		buildCompressed(compressedFanout)
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/binary"
+
+	"github.com/ShivainSaxena/go_database/simd"
+)
+
+// nodeLookupLEFast is the SIMD fast path for nodeLookupLE: when every key
+// in the node (and the search key itself) fits in packKeyBE's 7-byte
+// content budget, it packs them into big-endian uint64s — which preserves
+// byte-lexicographic order, including between a key and a longer key it's
+// a prefix of — and delegates the scan to simd.SearchLE. ok is false when
+// the fast path doesn't apply, in which case the caller falls back to the
+// byte-wise scan.
+func nodeLookupLEFast(node BNode, key []byte) (idx uint16, ok bool) {
+	if len(key) > 7 {
+		return 0, false
+	}
+
+	nkeys := node.nkeys()
+	if nkeys <= 1 {
+		return 0, true
+	}
+
+	// index 0 is always a valid (if possibly non-matching) lower bound by
+	// construction, same as the scalar scan below; only indices 1..n-1 are
+	// real candidates.
+	packed := make([]uint64, nkeys-1)
+	for i := uint16(1); i < nkeys; i++ {
+		k := node.getKey(i)
+		if len(k) > 7 {
+			return 0, false
+		}
+		packed[i-1] = packKeyBE(k)
+	}
+
+	found := simd.SearchLE(packed, packKeyBE(key))
+	if found < 0 {
+		return 0, true
+	}
+	return uint16(found) + 1, true
+}
+
+// packKeyBE packs key into a big-endian uint64 such that packKeyBE(a) <=
+// packKeyBE(b) iff bytes.Compare(a, b) <= 0, for any a, b of length <= 7.
+//
+// The first 7 bytes hold key right-padded with zeros; the 8th holds
+// len(key). The length byte is load-bearing: right-padding alone can't
+// distinguish a key from a longer key that starts with it and is zero
+// thereafter (e.g. "x" vs "x\x00" both right-pad to the same 7
+// zero-padded bytes), so without it those two distinct keys would compare
+// equal and the fast path would silently merge them, making the larger
+// one unreachable via Get. Appending the length breaks that tie in the
+// same direction bytes.Compare does: the shorter (proper-prefix) key
+// always sorts first.
+func packKeyBE(key []byte) uint64 {
+	var buf [8]byte
+	n := copy(buf[:7], key)
+	buf[7] = byte(n)
+	return binary.BigEndian.Uint64(buf[:])
+}
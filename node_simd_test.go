@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bytes"
+	"math/rand"
+	"sort"
+	"testing"
+)
+
+// nodeLookupLEScalar is the pre-SIMD reference implementation, kept here
+// only so the fuzz test below can check the fast path against it.
+func nodeLookupLEScalar(node BNode, key []byte) uint16 {
+	nkeys := node.nkeys()
+	found := uint16(0)
+	for i := uint16(1); i < nkeys; i++ {
+		cmp := bytes.Compare(node.getKey(i), key)
+		if cmp <= 0 {
+			found = i
+		}
+		if cmp >= 0 {
+			break
+		}
+	}
+	return found
+}
+
+// buildLeaf packs keys (already sorted ascending) and dummy values into a
+// freshly laid-out leaf node, for exercising nodeLookupLE directly without
+// going through BTREE.Insert.
+func buildLeaf(t *testing.T, keys [][]byte) BNode {
+	t.Helper()
+	node := BNode(make([]byte, BTREE_PAGE_SIZE))
+	node.setHeader(BNODE_LEAF, uint16(len(keys)))
+	for i, k := range keys {
+		nodeAppendKV(node, uint16(i), 0, k, []byte("v"))
+	}
+	return node
+}
+
+func TestNodeLookupLEFastMatchesScalar(t *testing.T) {
+	rng := rand.New(rand.NewSource(42))
+
+	for trial := 0; trial < 500; trial++ {
+		n := rng.Intn(40) + 1
+		keys := make([][]byte, n)
+		for i := range keys {
+			klen := rng.Intn(7) + 1 // keep within the fast path's 7-byte limit
+			buf := make([]byte, klen)
+			rng.Read(buf)
+			keys[i] = buf
+		}
+		// a leaf's keys must be sorted ascending with no duplicates (Insert
+		// updates an existing key in place rather than ever storing it
+		// twice); nodeLookupLEFast relies on that invariant.
+		sort.Slice(keys, func(i, j int) bool { return bytes.Compare(keys[i], keys[j]) < 0 })
+		deduped := keys[:0]
+		for i, k := range keys {
+			if i == 0 || !bytes.Equal(k, keys[i-1]) {
+				deduped = append(deduped, k)
+			}
+		}
+		keys = deduped
+		node := buildLeaf(t, keys)
+
+		for probe := 0; probe < 50; probe++ {
+			klen := rng.Intn(7) + 1
+			target := make([]byte, klen)
+			rng.Read(target)
+
+			got := nodeLookupLE(node, target)
+			want := nodeLookupLEScalar(node, target)
+			if got != want {
+				t.Fatalf("nodeLookupLE mismatch for keys=%v target=%x: got %d want %d", keys, target, got, want)
+			}
+		}
+	}
+}
+
+func TestNodeLookupLEFastSkipsOnLongKeys(t *testing.T) {
+	node := buildLeaf(t, [][]byte{[]byte("a"), []byte("this-key-is-longer-than-seven-bytes")})
+	if _, ok := nodeLookupLEFast(node, []byte("b")); ok {
+		t.Fatalf("fast path should decline when a node key exceeds 7 bytes")
+	}
+	// nodeLookupLE must still produce the right answer via the scalar fallback
+	got := nodeLookupLE(node, []byte("zzz"))
+	want := nodeLookupLEScalar(node, []byte("zzz"))
+	if got != want {
+		t.Fatalf("got %d want %d", got, want)
+	}
+}
+
+// TestNodeLookupLEFastPrefixZeroSuffix specifically targets the bug class
+// uniformly-random keys almost never hit: a key that is a proper prefix of
+// another, zero-padded the rest of the way (e.g. "x" and "x\x00"). Naive
+// right-padding alone would pack both to the same value and make the fast
+// path treat them as equal, when bytes.Compare says the shorter one is
+// strictly less.
+func TestNodeLookupLEFastPrefixZeroSuffix(t *testing.T) {
+	rng := rand.New(rand.NewSource(99))
+
+	for trial := 0; trial < 500; trial++ {
+		base := make([]byte, rng.Intn(5)+1)
+		rng.Read(base)
+		extra := rng.Intn(3) + 1
+		if len(base)+extra > 7 {
+			extra = 7 - len(base)
+		}
+		longer := append(append([]byte{}, base...), make([]byte, extra)...) // base + literal zero bytes
+
+		keys := [][]byte{append([]byte{}, base...), longer}
+		sort.Slice(keys, func(i, j int) bool { return bytes.Compare(keys[i], keys[j]) < 0 })
+		node := buildLeaf(t, keys)
+
+		for _, target := range [][]byte{base, longer} {
+			got := nodeLookupLE(node, target)
+			want := nodeLookupLEScalar(node, target)
+			if got != want {
+				t.Fatalf("keys=%x target=%x: got %d want %d", keys, target, got, want)
+			}
+		}
+	}
+}
+
+func TestPackKeyBEOrdersPrefixBeforeZeroSuffix(t *testing.T) {
+	cases := [][2][]byte{
+		{[]byte("x"), []byte("x\x00")},
+		{[]byte(""), []byte("\x00")},
+		{[]byte("ab"), []byte("ab\x00\x00")},
+	}
+	for _, c := range cases {
+		short, long := c[0], c[1]
+		if !(bytes.Compare(short, long) < 0) {
+			t.Fatalf("test case invariant broken: %q should sort before %q", short, long)
+		}
+		if !(packKeyBE(short) < packKeyBE(long)) {
+			t.Fatalf("packKeyBE(%q)=%d should be < packKeyBE(%q)=%d", short, packKeyBE(short), long, packKeyBE(long))
+		}
+	}
+}
@@ -0,0 +1,443 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"syscall"
+)
+
+// Pager is an mmap-backed page store used as the on-disk backing for a
+// BTREE via its get/new/del callbacks. It grows the backing file in
+// power-of-two increments (starting at 1 MiB, as bbolt and ristretto do)
+// and reuses freed pages out of a persistent free-list before extending
+// the file further.
+type Pager struct {
+	path     string
+	pageSize int
+
+	fd *os.File
+
+	dataMu  sync.RWMutex // guards data against concurrent Get during a grow
+	data    []byte       // current mmap, always a multiple of pageSize
+	retired [][]byte     // superseded mappings, kept mapped until Close
+
+	mu       sync.Mutex
+	txn      uint64 // meta sequence number, incremented on every flush
+	metaIdx  int    // which of the two meta pages (0 or 1) holds `txn`
+	root     uint64 // root pgid of the tree this pager backs
+	numPages uint64 // pages in use, including the 2 meta pages
+	freeHead uint64 // pgid of the head free-list page on disk (0 = none)
+
+	// in-memory cache of free pages, kept sorted by pgid. Each entry
+	// remembers the txid that freed it, so a caller with an MVCC reader
+	// registry (see DB) can withhold pages from reuse until no reader's
+	// snapshot could still reference them.
+	freelist []freeEntry
+
+	// pgids of the pages currently backing the persisted free-list on
+	// disk (the chain rooted at freeHead). Nothing ever reads these
+	// except OpenPager at startup, so once the next writeMeta's new
+	// free-list is durable, the old chain's pages are exactly as
+	// reusable as any other freed page -- see writeMeta.
+	freelistPages []uint64
+}
+
+type freeEntry struct {
+	pgid    uint64
+	freedAt uint64
+}
+
+const (
+	pagerMagic      = 0x42545245 // "BTRE"
+	pagerMetaPages  = 2          // pgid 0 and 1, alternating for crash safety
+	pagerMinMapSize = 1 << 20    // 1 MiB initial mmap, matches bbolt/ristretto
+
+	// meta page layout: magic | pageSize | txn | root | freeHead | numPages
+	metaPageSize = 4 + 4 + 8 + 8 + 8 + 8
+
+	// free-list page layout: type | count | next | (pgid, freedAt)...
+	freelistHeader   = 2 + 2 + 8
+	freelistEntry    = 8 + 8
+	freelistPageType = uint16(0xF1)
+)
+
+// OpenPager opens (creating if necessary) a pager-backed page file at path.
+func OpenPager(path string, pageSize int) (*Pager, error) {
+	fd, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("pager: open %s: %w", path, err)
+	}
+
+	p := &Pager{path: path, pageSize: pageSize, fd: fd}
+
+	fi, err := fd.Stat()
+	if err != nil {
+		fd.Close()
+		return nil, err
+	}
+
+	if fi.Size() == 0 {
+		if err := p.grow(pagerMinMapSize); err != nil {
+			fd.Close()
+			return nil, err
+		}
+		p.numPages = pagerMetaPages
+		p.metaIdx = 1 // writeMeta() writes into the other slot, i.e. 0, first
+		if err := p.writeMeta(); err != nil {
+			fd.Close()
+			return nil, err
+		}
+	} else {
+		if err := p.mmapFile(int(fi.Size())); err != nil {
+			fd.Close()
+			return nil, err
+		}
+		if err := p.readMeta(); err != nil {
+			fd.Close()
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+func (p *Pager) mmapFile(size int) error {
+	data, err := syscall.Mmap(int(p.fd.Fd()), 0, size, syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED)
+	if err != nil {
+		return fmt.Errorf("pager: mmap: %w", err)
+	}
+	p.dataMu.Lock()
+	if p.data != nil {
+		// Unmapping the old mapping here would invalidate any slice a
+		// concurrent Get() caller (a reader goroutine, racing this writer's
+		// Alloc-triggered grow) might still be reading. Since both mappings
+		// are MAP_SHARED over the same file, the old one keeps seeing
+		// exactly the same page contents as the new one; retire it instead
+		// of unmapping, and reclaim it only at Close, once nothing could
+		// still hold a reference into it.
+		p.retired = append(p.retired, p.data)
+	}
+	p.data = data
+	p.dataMu.Unlock()
+	return nil
+}
+
+func (p *Pager) grow(size int) error {
+	if err := p.fd.Truncate(int64(size)); err != nil {
+		return fmt.Errorf("pager: truncate: %w", err)
+	}
+	return p.mmapFile(size)
+}
+
+// ensure grows the mmap, doubling from pagerMinMapSize, until it covers at
+// least n pages.
+func (p *Pager) ensure(n uint64) error {
+	p.dataMu.RLock()
+	curSize := len(p.data)
+	p.dataMu.RUnlock()
+
+	need := int(n) * p.pageSize
+	if need <= curSize {
+		return nil
+	}
+	size := pagerMinMapSize
+	if curSize > size {
+		size = curSize
+	}
+	for size < need {
+		size *= 2
+	}
+	return p.grow(size)
+}
+
+// Get returns the raw bytes of page pgid. The returned slice aliases a
+// mapping that's retired, not unmapped, once superseded by a grow (see
+// mmapFile), so unlike a bare mmap slice it stays valid for the life of the
+// Pager, safe to read concurrently with another goroutine's writer growing
+// the file.
+func (p *Pager) Get(pgid uint64) []byte {
+	p.dataMu.RLock()
+	data := p.data
+	p.dataMu.RUnlock()
+
+	off := pgid * uint64(p.pageSize)
+	if off+uint64(p.pageSize) > uint64(len(data)) {
+		panic(fmt.Sprintf("pager: page %d out of range", pgid))
+	}
+	return data[off : off+uint64(p.pageSize)]
+}
+
+// Alloc copies data into a fresh page and returns its pgid. It first looks
+// for a free page freed at a txid strictly below safeTxid (i.e. one no
+// reader's snapshot could still reference); if none qualifies, it extends
+// the file instead of reusing a too-recent one.
+func (p *Pager) Alloc(data []byte, safeTxid uint64) uint64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if len(data) > p.pageSize {
+		panic("pager: page data larger than page size")
+	}
+
+	var pgid uint64
+	if i := p.reusableIndex(safeTxid); i >= 0 {
+		pgid = p.freelist[i].pgid
+		p.freelist = append(p.freelist[:i], p.freelist[i+1:]...)
+	} else {
+		pgid = p.numPages
+		p.numPages++
+		if err := p.ensure(p.numPages); err != nil {
+			panic(err)
+		}
+	}
+
+	copy(p.Get(pgid), data)
+	return pgid
+}
+
+// reusableIndex returns the index of a free-list entry safe to reuse under
+// safeTxid, or -1 if none qualifies. Picking the oldest eligible entry
+// keeps pgid reuse (and thus file size) bounded under steady churn.
+func (p *Pager) reusableIndex(safeTxid uint64) int {
+	best := -1
+	for i, e := range p.freelist {
+		if e.freedAt < safeTxid && (best < 0 || e.freedAt < p.freelist[best].freedAt) {
+			best = i
+		}
+	}
+	return best
+}
+
+// Free returns pgid to the free-list, tagged with freedAt (the txid that
+// made it unreachable), for reuse by a later Alloc once that's safe.
+func (p *Pager) Free(pgid uint64, freedAt uint64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.freeLocked(pgid, freedAt)
+}
+
+// freeLocked is Free's body, split out so writeMeta (already holding p.mu)
+// can reclaim the previous free-list's own storage pages without
+// recursively locking.
+func (p *Pager) freeLocked(pgid uint64, freedAt uint64) {
+	idx := sort.Search(len(p.freelist), func(i int) bool { return p.freelist[i].pgid >= pgid })
+	p.freelist = append(p.freelist, freeEntry{})
+	copy(p.freelist[idx+1:], p.freelist[idx:])
+	p.freelist[idx] = freeEntry{pgid: pgid, freedAt: freedAt}
+}
+
+// SetRoot updates the root pgid that gets persisted on the next Sync/Close.
+// Callers mutate a BTREE against this pager and then report back its new
+// root (full write-transaction semantics land in a later chunk).
+func (p *Pager) SetRoot(root uint64) {
+	p.mu.Lock()
+	p.root = root
+	p.mu.Unlock()
+}
+
+// NewBTree returns a BTREE backed directly by this pager's Get/Alloc/Free,
+// rooted at whatever root the pager currently has recorded (0 for a fresh
+// database), with no MVCC reader bookkeeping: pages are reused as soon as
+// they're freed. Use DB.Begin for snapshot isolation across readers.
+func (p *Pager) NewBTree() *BTREE {
+	return &BTREE{
+		root: p.root,
+		get:  p.Get,
+		new:  func(data []byte) uint64 { return p.Alloc(data, ^uint64(0)) },
+		del:  func(pgid uint64) { p.Free(pgid, 0) },
+	}
+}
+
+// Sync persists the free-list and an updated meta page, fsync'ing in
+// between so a crash can never observe a meta page pointing at a free-list
+// or root that wasn't itself durable yet.
+func (p *Pager) Sync() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.writeMeta()
+}
+
+// Close flushes a final meta page and unmaps/closes the backing file.
+func (p *Pager) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err := p.writeMeta(); err != nil {
+		return err
+	}
+	p.dataMu.Lock()
+	defer p.dataMu.Unlock()
+	for _, m := range p.retired {
+		if err := syscall.Munmap(m); err != nil {
+			return err
+		}
+	}
+	p.retired = nil
+	if p.data != nil {
+		if err := syscall.Munmap(p.data); err != nil {
+			return err
+		}
+		p.data = nil
+	}
+	return p.fd.Close()
+}
+
+// writeFreelist serializes the in-memory free-list into one or more pages,
+// chained by a "next" pointer in each page's header, and returns the head
+// pgid (0 if the free-list is empty).
+//
+// Nothing ever reads these pages except OpenPager at startup, via the
+// freeHead the meta page being written now will point at -- so as long as
+// this call's own page count doesn't grow, it reuses the previous round's
+// storage pgids (p.freelistPages) in place instead of abandoning them for
+// fresh tail pages every single write, which used to leak a page on every
+// Sync/Commit regardless of whether the tree's contents even changed. Only
+// the pages genuinely needed beyond what's being reused are taken from the
+// tail (still not recycled free pgids, to avoid recursively growing the
+// list it's in the middle of writing); any left over from a shrinking list
+// are freed like any other page this write made unreachable, available to
+// a future Alloc once that's safe.
+func (p *Pager) writeFreelist() (uint64, error) {
+	entries := p.freelist
+	perPage := (p.pageSize - freelistHeader) / freelistEntry
+	npages := 0
+	if len(entries) > 0 {
+		npages = (len(entries) + perPage - 1) / perPage
+	}
+
+	prev := p.freelistPages
+	reuse := npages
+	if reuse > len(prev) {
+		reuse = len(prev)
+	}
+	pgids := make([]uint64, npages)
+	copy(pgids, prev[:reuse])
+	for i := reuse; i < npages; i++ {
+		pgids[i] = p.numPages
+		p.numPages++
+	}
+	if err := p.ensure(p.numPages); err != nil {
+		return 0, err
+	}
+	for _, pgid := range prev[reuse:] {
+		p.freeLocked(pgid, p.txn+1)
+	}
+
+	for i, pgid := range pgids {
+		chunk := entries[i*perPage:]
+		if len(chunk) > perPage {
+			chunk = chunk[:perPage]
+		}
+		page := p.Get(pgid)
+		binary.LittleEndian.PutUint16(page[0:2], freelistPageType)
+		binary.LittleEndian.PutUint16(page[2:4], uint16(len(chunk)))
+		next := uint64(0)
+		if i+1 < len(pgids) {
+			next = pgids[i+1]
+		}
+		binary.LittleEndian.PutUint64(page[4:12], next)
+		for j, e := range chunk {
+			off := freelistHeader + freelistEntry*j
+			binary.LittleEndian.PutUint64(page[off:], e.pgid)
+			binary.LittleEndian.PutUint64(page[off+8:], e.freedAt)
+		}
+	}
+
+	p.freelistPages = pgids
+	if npages == 0 {
+		return 0, nil
+	}
+	return pgids[0], nil
+}
+
+func (p *Pager) readFreelist(head uint64) []freeEntry {
+	var entries []freeEntry
+	for pgid := head; pgid != 0; {
+		page := p.Get(pgid)
+		if binary.LittleEndian.Uint16(page[0:2]) != freelistPageType {
+			panic("pager: corrupt free-list page")
+		}
+		count := binary.LittleEndian.Uint16(page[2:4])
+		next := binary.LittleEndian.Uint64(page[4:12])
+		for j := uint16(0); j < count; j++ {
+			off := freelistHeader + freelistEntry*int(j)
+			entries = append(entries, freeEntry{
+				pgid:    binary.LittleEndian.Uint64(page[off:]),
+				freedAt: binary.LittleEndian.Uint64(page[off+8:]),
+			})
+		}
+		pgid = next
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].pgid < entries[j].pgid })
+	return entries
+}
+
+// writeMeta flushes the free-list, then writes a new meta page into
+// whichever of pgid 0/1 was NOT written last, fsync'ing before and after so
+// the previous meta page always remains valid if this one is torn by a
+// crash.
+func (p *Pager) writeMeta() error {
+	freeHead, err := p.writeFreelist()
+	if err != nil {
+		return err
+	}
+	if err := p.msync(); err != nil {
+		return err
+	}
+
+	idx := 1 - p.metaIdx
+	page := p.Get(uint64(idx))
+	txn := p.txn + 1
+	binary.LittleEndian.PutUint32(page[0:4], pagerMagic)
+	binary.LittleEndian.PutUint32(page[4:8], uint32(p.pageSize))
+	binary.LittleEndian.PutUint64(page[8:16], txn)
+	binary.LittleEndian.PutUint64(page[16:24], p.root)
+	binary.LittleEndian.PutUint64(page[24:32], freeHead)
+	binary.LittleEndian.PutUint64(page[32:40], p.numPages)
+
+	if err := p.msync(); err != nil {
+		return err
+	}
+	p.metaIdx = idx
+	p.txn = txn
+	p.freeHead = freeHead
+	return nil
+}
+
+func (p *Pager) readMeta() error {
+	type meta struct {
+		txn, root, freeHead, numPages uint64
+	}
+	var best *meta
+	bestIdx := -1
+	for idx := 0; idx < pagerMetaPages; idx++ {
+		page := p.Get(uint64(idx))
+		if binary.LittleEndian.Uint32(page[0:4]) != pagerMagic {
+			continue
+		}
+		m := &meta{
+			txn:      binary.LittleEndian.Uint64(page[8:16]),
+			root:     binary.LittleEndian.Uint64(page[16:24]),
+			freeHead: binary.LittleEndian.Uint64(page[24:32]),
+			numPages: binary.LittleEndian.Uint64(page[32:40]),
+		}
+		if best == nil || m.txn > best.txn {
+			best, bestIdx = m, idx
+		}
+	}
+	if best == nil {
+		return fmt.Errorf("pager: no valid meta page found in %s", p.path)
+	}
+	p.txn = best.txn
+	p.root = best.root
+	p.freeHead = best.freeHead
+	p.numPages = best.numPages
+	p.metaIdx = bestIdx
+	p.freelist = p.readFreelist(best.freeHead)
+	return nil
+}
+
+func (p *Pager) msync() error {
+	return p.fd.Sync()
+}
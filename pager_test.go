@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+)
+
+func openTestPager(t *testing.T) *Pager {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "test.db")
+	p, err := OpenPager(path, BTREE_PAGE_SIZE)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	t.Cleanup(func() { p.Close() })
+	return p
+}
+
+func TestPagerAllocGetFree(t *testing.T) {
+	p := openTestPager(t)
+
+	payload := bytes.Repeat([]byte("x"), 100)
+	pgid := p.Alloc(payload, ^uint64(0))
+	if got := p.Get(pgid)[:len(payload)]; !bytes.Equal(got, payload) {
+		t.Fatalf("Get after Alloc mismatch: got %q want %q", got, payload)
+	}
+
+	p.Free(pgid, 0)
+	reused := p.Alloc(bytes.Repeat([]byte("y"), 50), ^uint64(0))
+	if reused != pgid {
+		t.Fatalf("expected Alloc to reuse freed pgid %d, got %d", pgid, reused)
+	}
+}
+
+func TestPagerGrowsMmap(t *testing.T) {
+	p := openTestPager(t)
+
+	payload := make([]byte, BTREE_PAGE_SIZE)
+	var pgids []uint64
+	// allocate enough pages to force the mmap past its initial 1 MiB size
+	for i := 0; i < (pagerMinMapSize/BTREE_PAGE_SIZE)+10; i++ {
+		pgids = append(pgids, p.Alloc(payload, ^uint64(0)))
+	}
+	for _, pgid := range pgids {
+		_ = p.Get(pgid) // must not panic: page must be within the grown mmap
+	}
+}
+
+func TestPagerPersistsAcrossReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+
+	p, err := OpenPager(path, BTREE_PAGE_SIZE)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	tree := p.NewBTree()
+	tree.Insert([]byte("hello"), []byte("world"))
+	p.SetRoot(tree.root)
+	if err := p.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	p2, err := OpenPager(path, BTREE_PAGE_SIZE)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer p2.Close()
+
+	tree2 := p2.NewBTree()
+	val, ok := tree2.Get([]byte("hello"))
+	if !ok || string(val) != "world" {
+		t.Fatalf("expected persisted value, got %q ok=%v", val, ok)
+	}
+}
+
+// TestPagerSyncDoesNotLeakFreelistPages is a regression test for a bug
+// where writeFreelist always allocated brand-new tail pages for the
+// serialized free-list and never reclaimed the pages the previous round
+// used, so every Sync leaked at least one page even with nothing new to
+// free.
+func TestPagerSyncDoesNotLeakFreelistPages(t *testing.T) {
+	p := openTestPager(t)
+
+	pgid := p.Alloc(bytes.Repeat([]byte("z"), 10), ^uint64(0))
+	p.Free(pgid, 0)
+	if err := p.Sync(); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+
+	before := p.numPages
+	for i := 0; i < 20; i++ {
+		if err := p.Sync(); err != nil {
+			t.Fatalf("Sync: %v", err)
+		}
+	}
+	if p.numPages != before {
+		t.Fatalf("repeated no-op Sync leaked pages: numPages went %d -> %d", before, p.numPages)
+	}
+}
+
+func TestPagerFreelistPersistsAcrossReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+
+	p, err := OpenPager(path, BTREE_PAGE_SIZE)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	pgid := p.Alloc(bytes.Repeat([]byte("z"), 10), ^uint64(0))
+	p.Free(pgid, 0)
+	if err := p.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	p2, err := OpenPager(path, BTREE_PAGE_SIZE)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer p2.Close()
+
+	reused := p2.Alloc(bytes.Repeat([]byte("w"), 10), ^uint64(0))
+	if reused != pgid {
+		t.Fatalf("expected reopen to recover free pgid %d from disk, got %d", pgid, reused)
+	}
+}
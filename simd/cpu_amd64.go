@@ -0,0 +1,26 @@
+//go:build amd64
+
+package simd
+
+//go:noescape
+func cpuid(eaxArg, ecxArg uint32) (eax, ebx, ecx, edx uint32)
+
+//go:noescape
+func xgetbv() uint64
+
+// hasAVX2 reports whether AVX2 is both present and enabled by the OS (the
+// latter is what XGETBV's XCR0 tells us: bits 1 and 2 mean the OS saves/
+// restores SSE and AVX register state across context switches).
+func detectAVX2() bool {
+	_, _, ecx1, _ := cpuid(1, 0)
+	const osxsaveBit = 1 << 27
+	if ecx1&osxsaveBit == 0 {
+		return false
+	}
+	if xgetbv()&0x6 != 0x6 {
+		return false
+	}
+	_, ebx7, _, _ := cpuid(7, 0)
+	const avx2Bit = 1 << 5
+	return ebx7&avx2Bit != 0
+}
@@ -0,0 +1,26 @@
+// Package simd provides an accelerated search over a node's keys once
+// they've been packed into fixed-width uint64s (one per key, big-endian,
+// zero-padded on the right so numeric order matches byte-lexicographic
+// order). On amd64 it uses an AVX2 implementation that compares 4 keys per
+// instruction; elsewhere, and when AVX2 isn't available at runtime, it
+// falls back to a portable Go loop.
+package simd
+
+// SearchLE returns the index of the largest element in the ascending,
+// packed-key slice keys that is <= target, or -1 if every element is
+// greater than target (or keys is empty).
+func SearchLE(keys []uint64, target uint64) int {
+	return searchLE(keys, target)
+}
+
+func searchLEGeneric(keys []uint64, target uint64) int {
+	found := -1
+	for i, k := range keys {
+		if k <= target {
+			found = i
+		} else {
+			break
+		}
+	}
+	return found
+}
@@ -0,0 +1,15 @@
+//go:build amd64
+
+package simd
+
+var hasAVX2 = detectAVX2()
+
+//go:noescape
+func searchLEAVX2(keys []uint64, target uint64) int32
+
+func searchLE(keys []uint64, target uint64) int {
+	if hasAVX2 && len(keys) >= 4 {
+		return int(searchLEAVX2(keys, target))
+	}
+	return searchLEGeneric(keys, target)
+}
@@ -0,0 +1,7 @@
+//go:build !amd64
+
+package simd
+
+func searchLE(keys []uint64, target uint64) int {
+	return searchLEGeneric(keys, target)
+}
@@ -0,0 +1,115 @@
+package simd
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func makeBenchKeys(n int) []uint64 {
+	keys := make([]uint64, n)
+	for i := range keys {
+		keys[i] = uint64(i) * 7
+	}
+	return keys
+}
+
+// 256 is roughly the largest fanout a 4KiB BTREE node reaches today (see
+// BTREE_PAGE_SIZE): at that size the AVX2 path's fixed per-call overhead
+// (bias broadcast, mask extraction) isn't yet paid off by its 4-keys-per-
+// instruction throughput. BenchmarkSearchLELarge shows where it wins.
+func BenchmarkSearchLEGenericNodeSized(b *testing.B) {
+	keys := makeBenchKeys(256)
+	target := keys[255]
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		searchLEGeneric(keys, target)
+	}
+}
+
+func BenchmarkSearchLENodeSized(b *testing.B) {
+	keys := makeBenchKeys(256)
+	target := keys[255]
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		SearchLE(keys, target)
+	}
+}
+
+func BenchmarkSearchLEGenericLarge(b *testing.B) {
+	keys := makeBenchKeys(4096)
+	target := keys[4095]
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		searchLEGeneric(keys, target)
+	}
+}
+
+func BenchmarkSearchLELarge(b *testing.B) {
+	keys := makeBenchKeys(4096)
+	target := keys[4095]
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		SearchLE(keys, target)
+	}
+}
+
+func TestSearchLEGenericMatchesNaive(t *testing.T) {
+	cases := [][]uint64{
+		{},
+		{5},
+		{1, 2, 3, 4, 5},
+		{10, 20, 30, 40, 50, 60, 70},
+	}
+	for _, keys := range cases {
+		for target := uint64(0); target < 80; target++ {
+			want := -1
+			for i, k := range keys {
+				if k <= target {
+					want = i
+				} else {
+					break
+				}
+			}
+			if got := searchLEGeneric(keys, target); got != want {
+				t.Fatalf("searchLEGeneric(%v, %d) = %d, want %d", keys, target, got, want)
+			}
+		}
+	}
+}
+
+func TestSearchLEFuzz(t *testing.T) {
+	rng := rand.New(rand.NewSource(7))
+	for trial := 0; trial < 2000; trial++ {
+		n := rng.Intn(20)
+		keys := make([]uint64, n)
+		var v uint64
+		for i := range keys {
+			v += uint64(rng.Intn(5)) // allow duplicates/gaps, stays ascending
+			keys[i] = v
+		}
+		target := v + uint64(rng.Intn(10)) - 5 // may land below, inside, or above the range
+
+		want := searchLEGeneric(keys, target)
+		got := SearchLE(keys, target)
+		if got != want {
+			t.Fatalf("SearchLE(%v, %d) = %d, want %d (generic)", keys, target, got, want)
+		}
+	}
+}
+
+func TestSearchLEHighBitKeys(t *testing.T) {
+	// keys with the MSB set must still compare as unsigned, not signed.
+	keys := []uint64{0x0000000000000001, 0x7FFFFFFFFFFFFFFF, 0x8000000000000000, 0xFFFFFFFFFFFFFFFF}
+	for target, want := range map[uint64]int{
+		0:                  -1,
+		1:                  0,
+		0x7FFFFFFFFFFFFFFF: 1,
+		0x8000000000000000: 2,
+		0xFFFFFFFFFFFFFFFE: 2,
+		0xFFFFFFFFFFFFFFFF: 3,
+	} {
+		if got := SearchLE(keys, target); got != want {
+			t.Fatalf("SearchLE(%v, %#x) = %d, want %d", keys, target, got, want)
+		}
+	}
+}
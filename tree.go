@@ -64,10 +64,30 @@ const (
 	BNODE_LEAF = 2 // leaf nodes w/ values
 )
 
+// BNODE_COMPRESSED is an orthogonal flag bit, OR'd into btype alongside
+// BNODE_NODE/BNODE_LEAF, that selects the prefix-compressed KV layout (see
+// node_compressed.go) instead of the plain one below. A node's kind is
+// still btype() &^ BNODE_COMPRESSED.
+const BNODE_COMPRESSED = 4
+
 func (node BNode) btype() uint16 {
 	return binary.LittleEndian.Uint16(node[0:2])
 }
 
+// isCompressed reports whether this node uses the prefix-compressed KV
+// layout (node_compressed.go) rather than the plain one.
+func (node BNode) isCompressed() bool {
+	return node.btype()&BNODE_COMPRESSED != 0
+}
+
+// kind returns the node's structural type (BNODE_NODE or BNODE_LEAF) with
+// BNODE_COMPRESSED masked off. Every caller that branches on "is this a
+// leaf or an internal node" (as opposed to "is this compressed") must use
+// kind(), not btype(), or a compressed node fails the comparison.
+func (node BNode) kind() uint16 {
+	return node.btype() &^ BNODE_COMPRESSED
+}
+
 func (node BNode) nkeys() uint16 {
 	return binary.LittleEndian.Uint16(node[2:4])
 }
@@ -124,23 +144,58 @@ func (node BNode) kvPos(idx uint16) uint16 {
 	return HEADER + 8*node.nkeys() + 2*node.nkeys() + node.getOffset(idx)
 }
 
+// rawKV reads the plain (uncompressed) KV layout at idx: this is always
+// the layout used for idx 0 (the anchor, see node_compressed.go), and for
+// every idx when the node isn't compressed.
+func (node BNode) rawKV(idx uint16) (key, val []byte) {
+	pos := node.kvPos(idx)
+	klen := binary.LittleEndian.Uint16(node[pos:])
+	vlen := binary.LittleEndian.Uint16(node[pos+2:])
+	key = node[pos+4:][:klen]
+	val = node[pos+4+klen:][:vlen]
+	return key, val
+}
+
 func (node BNode) getKey(idx uint16) []byte {
 	if idx >= node.nkeys() {
 		panic("Index out of bounds")
 	}
-	pos := node.kvPos(idx)
-	klen := binary.LittleEndian.Uint16(node[pos:])
-	return node[pos + 4:][:klen]
+	if node.isCompressed() && idx > 0 {
+		return node.getKeyInto(idx, nil)
+	}
+	key, _ := node.rawKV(idx)
+	return key
+}
+
+// getKeyInto reconstructs the key at idx into dst (reusing its backing
+// array when large enough) and returns the populated slice. For a
+// compressed node this avoids the allocation getKey(idx) would otherwise
+// need to stitch the anchor prefix and stored suffix back together, which
+// matters when a caller (e.g. a cursor scan) calls it once per key.
+func (node BNode) getKeyInto(idx uint16, dst []byte) []byte {
+	if idx >= node.nkeys() {
+		panic("Index out of bounds")
+	}
+	if !node.isCompressed() || idx == 0 {
+		key, _ := node.rawKV(idx)
+		return append(dst[:0], key...)
+	}
+	anchor, _ := node.rawKV(0)
+	prefixLen, suffix := node.compressedPrefixSuffix(idx)
+	dst = append(dst[:0], anchor[:prefixLen]...)
+	dst = append(dst, suffix...)
+	return dst
 }
 
 func (node BNode) getVal(idx uint16) []byte {
 	if idx >= node.nkeys() {
 		panic("Index out of bounds")
 	}
-	pos := node.kvPos(idx)
-	klen := binary.LittleEndian.Uint16(node[pos:])
-	vlen := binary.LittleEndian.Uint16(node[pos + 2:])
-	return node[pos + 4 + klen:][:vlen]
+	if node.isCompressed() && idx > 0 {
+		return node.compressedVal(idx)
+	}
+	_, val := node.rawKV(idx)
+	return val
 }
 
 // node size in bytes
@@ -150,7 +205,14 @@ func (node BNode) nbytes() uint16 {
 
 // goes through all keys in a node and finds the largest key that is less than or equal to given key
 // allows us to figure out where to go next (which child page to follow)
+// Works unchanged against a compressed node: it only ever reads keys
+// through getKey/nodeLookupLEFast, both of which already reconstruct the
+// full key for a compressed node's entries (see node_compressed.go).
 func nodeLookupLE(node BNode, key []byte) uint16 {
+	if idx, ok := nodeLookupLEFast(node, key); ok {
+		return idx
+	}
+
 	nkeys := node.nkeys()
 	found := uint16(0)
 
@@ -170,7 +232,7 @@ func nodeLookupLE(node BNode, key []byte) uint16 {
 
 // add a new key to a leaf node
 func leafInsert(new BNode, old BNode, idx uint16, key []byte, val []byte) {
-	new.setHeader(BNODE_LEAF, old.nkeys() + 1)
+	new.setHeader(old.btype(), old.nkeys() + 1) // preserves BNODE_COMPRESSED
 	nodeAppendRange(new, old, 0, 0, idx) // All keys from old before position idx
 	nodeAppendKV(new, idx, 0, key, val) // New (key, val) inserted at position idx
 	nodeAppendRange(new, old, idx + 1, idx, old.nkeys()-idx) // All keys from old after position idx
@@ -179,6 +241,11 @@ func leafInsert(new BNode, old BNode, idx uint16, key []byte, val []byte) {
 func nodeAppendKV(new BNode, idx uint16, ptr uint64, key []byte, val []byte) {
 	new.setPtr(idx, ptr)
 
+	if new.isCompressed() && idx > 0 {
+		nodeAppendKVCompressed(new, idx, key, val)
+		return
+	}
+
 	pos := new.kvPos(idx)
 	binary.LittleEndian.PutUint16(new[pos + 0:], uint16(len(key)))
 	binary.LittleEndian.PutUint16(new[pos + 2:], uint16(len(val)))
@@ -193,6 +260,20 @@ func nodeAppendRange(new BNode, old BNode, dstNew uint16, srcOld uint16, n uint1
 		return
 	}
 
+	if new.isCompressed() {
+		// A compressed entry's prefix length is relative to `new`'s own
+		// anchor (its idx 0), not `old`'s, so a raw byte-range copy would
+		// only be valid when the two happen to share an anchor. Simpler
+		// and always correct: re-derive each key/val through getKey/getVal
+		// (anchor-aware, see node_compressed.go) and re-append one at a
+		// time; nodeAppendKV re-anchors automatically the moment it writes
+		// `new`'s idx 0.
+		for i := uint16(0); i < n; i++ {
+			nodeAppendKV(new, dstNew+i, old.getPtr(srcOld+i), old.getKey(srcOld+i), old.getVal(srcOld+i))
+		}
+		return
+	}
+
 	// pointers
 	for i := uint16(0); i < n; i++ {
 		new.setPtr(dstNew+i, old.getPtr(srcOld+i))